@@ -159,6 +159,9 @@ func handleValidateOutput(ctx context.Context, request mcp.CallToolRequest, args
 
 func handleExtract(ctx context.Context, request mcp.CallToolRequest, args InputJSONRequest) (OutputJSONResponse, error) {
 	_, errInfo := enforceSchemaV2(args)
+	if errInfo == nil {
+		errInfo = rejectRecordSource(args)
+	}
 	if errInfo != nil {
 		return OutputJSONResponse{Error: errInfo}, nil
 	}
@@ -169,7 +172,7 @@ func handleExtract(ctx context.Context, request mcp.CallToolRequest, args InputJ
 	var output string
 	err := runWithTimeout(ctx, func() error {
 		var err error
-		output, err = extraction.Extract(args.InputJSON)
+		output, err = extraction.ExtractContext(ctx, args.InputJSON)
 		return err
 	})
 	if err != nil {
@@ -181,6 +184,9 @@ func handleExtract(ctx context.Context, request mcp.CallToolRequest, args InputJ
 
 func handleComputeCosts(ctx context.Context, request mcp.CallToolRequest, args InputJSONRequest) (OutputJSONResponse, error) {
 	version, errInfo := enforceSchemaV2(args)
+	if errInfo == nil {
+		errInfo = rejectRecordSource(args)
+	}
 	if errInfo != nil {
 		return OutputJSONResponse{Error: errInfo}, nil
 	}
@@ -235,6 +241,23 @@ func enforceSchemaV2(args InputJSONRequest) (string, *ErrorInfo) {
 	return "v2", nil
 }
 
+// rejectRecordSource refuses inputs whose prompt templates read their records from a file:
+// MCP clients must not make the server read its local files, so only inline records are accepted.
+func rejectRecordSource(args InputJSONRequest) *ErrorInfo {
+	var payload struct {
+		Templates *struct {
+			Source json.RawMessage `json:"source"`
+		} `json:"templates"`
+	}
+	if err := json.Unmarshal([]byte(args.InputJSON), &payload); err != nil {
+		return nil
+	}
+	if payload.Templates != nil && len(payload.Templates.Source) > 0 && string(payload.Templates.Source) != "null" {
+		return errorInfo(400, "template record sources are not supported by alembica-mcp; pass the records inline")
+	}
+	return nil
+}
+
 func errorInfo(code int, message string) *ErrorInfo {
 	return &ErrorInfo{
 		Code:    code,
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestHandlersRejectRecordSources(t *testing.T) {
+	inputJSON := `{
+		"metadata": {"schemaVersion": "v2", "timestamp": "2026-01-20T00:00:00Z"},
+		"models": [{"provider": "OpenAI", "model": "gpt-4o", "temperature": 0}],
+		"prompts": [],
+		"templates": {
+			"prompts": [{"promptContent": "Summarize: {{abstract}}", "sequenceNumber": 1}],
+			"source": {"path": "/etc/passwd", "format": "csv"}
+		}
+	}`
+
+	handlers := map[string]func(context.Context, mcp.CallToolRequest, InputJSONRequest) (OutputJSONResponse, error){
+		"alembica_extract":       handleExtract,
+		"alembica_compute_costs": handleComputeCosts,
+	}
+	for name, handle := range handlers {
+		t.Run(name, func(t *testing.T) {
+			result, err := handle(context.Background(), mcp.CallToolRequest{}, InputJSONRequest{InputJSON: inputJSON})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Error == nil || result.Error.Code != 400 || !strings.Contains(result.Error.Message, "record sources") {
+				t.Errorf("expected the record source to be rejected, got %+v", result)
+			}
+		})
+	}
+}
@@ -10,9 +10,11 @@ Core Components:
   - Data Structures:
   - `Input`: Represents an AI model request with metadata, models, and prompts.
   - `Output`: Represents an AI model response with metadata and generated answers.
+  - `ExpandTemplates`: Generates prompt sequences from templates and records.
   - Schema Management:
   - `LoadSchema`: Loads and stores JSON schemas for input/output validation.
   - `SchemaStore`: Holds different versions of schema files.
+  - `LookupSchema`: Returns a loaded schema, safely while providers are registered.
   - Token Counting:
   - `RealTokenCounter`: Calculates token counts using provider-specific APIs.
 
@@ -2,9 +2,13 @@ package definitions
 
 import (
 	"embed"
+	"encoding/json"
 	"fmt"
-	"github.com/xeipuuv/gojsonschema"
 	"log"
+	"slices"
+	"sync"
+
+	"github.com/xeipuuv/gojsonschema"
 )
 
 // Embed all schema files in the definitions directory.
@@ -15,8 +19,36 @@ var schemas embed.FS
 
 // SchemaStore holds different versions and types of JSON schemas for validation.
 // It maps schema versions and types (e.g., "input", "output") to preloaded schemas.
+// Use LookupSchema to read it while providers may be registered.
 var SchemaStore = make(map[string]map[string]*gojsonschema.Schema)
 
+// schemaMu guards SchemaStore and customProviders, which RegisterProvider may change while
+// schemas are loaded and looked up.
+var schemaMu sync.RWMutex
+
+// LookupSchema returns a loaded schema.
+//
+// Parameters:
+//   - version: The version of the schema (e.g., "v1").
+//   - schemaType: The type of schema (e.g., "input", "output").
+//
+// Returns:
+//   - The schema.
+//   - An error if no schema of the version, or of the type in the version, is loaded.
+func LookupSchema(version, schemaType string) (*gojsonschema.Schema, error) {
+	schemaMu.RLock()
+	defer schemaMu.RUnlock()
+	schemaMap, versionExists := SchemaStore[version]
+	if !versionExists {
+		return nil, fmt.Errorf("no schemas found for version %s", version)
+	}
+	schema, typeExists := schemaMap[schemaType]
+	if !typeExists {
+		return nil, fmt.Errorf("no schema found for type %s in version %s", schemaType, version)
+	}
+	return schema, nil
+}
+
 // LoadSchema loads a JSON schema from the embedded filesystem into the SchemaStore.
 //
 // Parameters:
@@ -38,6 +70,14 @@ func LoadSchema(version, schemaType string) error {
 	if err != nil {
 		return fmt.Errorf("no schema file found for version %s and type %s at %s", version, schemaType, schemaPath)
 	}
+	schemaMu.RLock()
+	providers := slices.Clone(customProviders)
+	schemaMu.RUnlock()
+	if version == providersVersion && schemaType == "input" && len(providers) > 0 {
+		if schemaData, err = withCustomProviders(schemaData, providers); err != nil {
+			return fmt.Errorf("failed to add registered providers to schema %s: %v", schemaPath, err)
+		}
+	}
 
 	loader := gojsonschema.NewBytesLoader(schemaData)
 	schema, err := gojsonschema.NewSchema(loader)
@@ -45,6 +85,8 @@ func LoadSchema(version, schemaType string) error {
 		return fmt.Errorf("failed to load schema %s: %v", schemaPath, err)
 	}
 
+	schemaMu.Lock()
+	defer schemaMu.Unlock()
 	if SchemaStore[version] == nil {
 		SchemaStore[version] = make(map[string]*gojsonschema.Schema)
 	}
@@ -52,6 +94,49 @@ func LoadSchema(version, schemaType string) error {
 	return nil
 }
 
+// providersVersion is the schema version that accepts providers registered with RegisterProvider.
+const providersVersion = "v2"
+
+// customProviders lists the providers registered with RegisterProvider.
+var customProviders []string
+
+// RegisterProvider adds a provider to those accepted by the v2 input schema. The schema is
+// reloaded the next time it is used. It is called by model.RegisterProvider.
+//
+// Parameters:
+//   - name: The name of the provider.
+func RegisterProvider(name string) {
+	schemaMu.Lock()
+	defer schemaMu.Unlock()
+	if slices.Contains(customProviders, name) {
+		return
+	}
+	customProviders = append(customProviders, name)
+	delete(SchemaStore[providersVersion], "input")
+}
+
+// withCustomProviders adds registered providers to the provider enumeration of a model
+// in an input schema.
+func withCustomProviders(schemaData []byte, providers []string) ([]byte, error) {
+	var schema map[string]any
+	if err := json.Unmarshal(schemaData, &schema); err != nil {
+		return nil, err
+	}
+	definitions, _ := schema["definitions"].(map[string]any)
+	model, _ := definitions["model"].(map[string]any)
+	properties, _ := model["properties"].(map[string]any)
+	provider, _ := properties["provider"].(map[string]any)
+	enum, ok := provider["enum"].([]any)
+	if !ok {
+		return nil, fmt.Errorf("no provider enumeration")
+	}
+	for _, name := range providers {
+		enum = append(enum, name)
+	}
+	provider["enum"] = enum
+	return json.Marshal(schema)
+}
+
 // init initializes the SchemaStore when the package is loaded.
 // It preloads schemas for predefined versions and types to ensure they are available at runtime.
 func init() {
@@ -1,8 +1,11 @@
 package definitions
 
 import (
-	"github.com/xeipuuv/gojsonschema"
+	"fmt"
+	"sync"
 	"testing"
+
+	"github.com/xeipuuv/gojsonschema"
 )
 
 func TestLoadSchema(t *testing.T) {
@@ -71,3 +74,32 @@ func TestSchemaValidation(t *testing.T) {
 		t.Fatalf("Expected valid document but got validation errors: %v", result.Errors())
 	}
 }
+
+func TestRegisterProviderConcurrently(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			RegisterProvider(fmt.Sprintf("ConcurrentGateway%d", i))
+		}()
+		go func() {
+			defer wg.Done()
+			if err := LoadSchema("v2", "input"); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			LookupSchema("v2", "input")
+		}()
+	}
+	wg.Wait()
+
+	if err := LoadSchema("v2", "input"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := LookupSchema("v2", "input"); err != nil {
+		t.Errorf("expected the reloaded schema, got %v", err)
+	}
+	if _, err := LookupSchema("v99", "input"); err == nil {
+		t.Errorf("expected an error for an unknown version")
+	}
+}
@@ -1,5 +1,7 @@
 package definitions
 
+import "encoding/json"
+
 // Define input structures
 type InputMetadata struct {
 	Version       string `json:"version"`
@@ -8,40 +10,136 @@ type InputMetadata struct {
 }
 
 type Model struct {
-	Provider     string  `json:"provider"`
-	APIKey       string  `json:"api_key"`
-	Model        string  `json:"model"`
-	Temperature  float64 `json:"temperature"`
-	TPMLimit     int     `json:"tpm_limit"`
-	RPMLimit     int     `json:"rpm_limit"`
-	BaseURL      string  `json:"base_url,omitempty"`
-	EndpointType string  `json:"endpoint_type,omitempty"`
-	Region       string  `json:"region,omitempty"`
-	ProjectID    string  `json:"project_id,omitempty"`
-	Location     string  `json:"location,omitempty"`
-	APIVersion   string  `json:"api_version,omitempty"`
+	Provider     string       `json:"provider"`
+	APIKey       string       `json:"api_key"`
+	Model        string       `json:"model"`
+	Temperature  float64      `json:"temperature"`
+	TPMLimit     int          `json:"tpm_limit"`
+	RPMLimit     int          `json:"rpm_limit"`
+	BaseURL      string       `json:"base_url,omitempty"`
+	EndpointType string       `json:"endpoint_type,omitempty"`
+	Region       string       `json:"region,omitempty"`
+	ProjectID    string       `json:"project_id,omitempty"`
+	Location     string       `json:"location,omitempty"`
+	APIVersion   string       `json:"api_version,omitempty"`
+	Concurrency  int          `json:"concurrency,omitempty"`
+	Samples      int          `json:"samples,omitempty"`
+	Retry        *RetryPolicy `json:"retry,omitempty"`
+	Fallbacks    []Model      `json:"fallbacks,omitempty"`     // Tried in order when the model fails a sequence
+	RepairJSON   bool         `json:"repair_json,omitempty"`   // Repair defective JSON in answers
+	MaxReasks    int          `json:"max_reasks,omitempty"`    // Corrective follow-ups for answers failing validation
+	SystemPrompt string       `json:"system_prompt,omitempty"` // Instructions in the system role
+	Stateless    bool         `json:"stateless,omitempty"`     // Send each prompt without the conversation history
+
+	// Generation parameters; unset ones keep the provider defaults
+	MaxOutputTokens  int            `json:"max_output_tokens,omitempty"`
+	TopP             *float64       `json:"top_p,omitempty"`
+	TopK             int            `json:"top_k,omitempty"`
+	Seed             *int64         `json:"seed,omitempty"`
+	Stop             []string       `json:"stop,omitempty"`
+	PresencePenalty  *float64       `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64       `json:"frequency_penalty,omitempty"`
+	ExtraParams      map[string]any `json:"extra_params,omitempty"` // Merged into the request body as is
+
+	// Ollama settings
+	KeepAlive string         `json:"keep_alive,omitempty"` // How long the model stays loaded, such as "10m"
+	NumCtx    int            `json:"num_ctx,omitempty"`    // Context window in tokens
+	Options   map[string]any `json:"options,omitempty"`    // Model options, overriding those set above
+}
+
+// RetryPolicy configures how failed provider calls of a model are retried.
+type RetryPolicy struct {
+	MaxAttempts int      `json:"max_attempts"`            // Total attempts per call, including the first one
+	BaseDelayMs int      `json:"base_delay_ms,omitempty"` // Delay before the second attempt, doubled for each further one
+	MaxDelayMs  int      `json:"max_delay_ms,omitempty"`  // Upper bound of the delay between attempts
+	Jitter      float64  `json:"jitter,omitempty"`        // Fraction of the delay randomized, between 0 and 1
+	RetryOn     []string `json:"retry_on,omitempty"`      // Retryable error categories; transient ones by default
 }
 
 type Prompt struct {
-	PromptContent  string `json:"promptContent"`
-	SequenceID     string `json:"sequenceId"`
-	SequenceNumber int    `json:"sequenceNumber"`
+	PromptContent  string          `json:"promptContent"`
+	SequenceID     string          `json:"sequenceId"`
+	SequenceNumber int             `json:"sequenceNumber"`
+	ResponseSchema json.RawMessage `json:"responseSchema,omitempty"` // JSON Schema the answer must conform to
+	SystemPrompt   string          `json:"systemPrompt,omitempty"`   // Applies to the whole sequence
+	ResponseFormat string          `json:"responseFormat,omitempty"` // text, json or json_schema; json unless a schema is set
+	RecordID       string          `json:"recordId,omitempty"`       // Record the prompt was expanded from
+	Condition      *Condition      `json:"condition,omitempty"`      // Sends the prompt only if it holds
+	Examples       []Example       `json:"examples,omitempty"`       // Turns sent before the prompt
+}
+
+// Example is a few-shot exchange sent to the model as earlier conversation turns: a user input
+// and the ideal answer to it.
+type Example struct {
+	Input  string          `json:"input"`
+	Output json.RawMessage `json:"output"` // Ideal JSON answer; a JSON string for text prompts
+}
+
+// Condition decides from the earlier answers of a sequence whether a prompt is sent. It is
+// either an expression or the equality of the value at a path with a JSON value.
+type Condition struct {
+	Expression string          `json:"expression,omitempty"` // Such as "step1.included == true && step1.score >= 3"
+	Path       string          `json:"path,omitempty"`       // Such as "step1.included", compared with Equals
+	Equals     json.RawMessage `json:"equals,omitempty"`
+	Otherwise  string          `json:"otherwise,omitempty"` // skip (default), stop, or stepN to jump to prompt N
 }
 
 type Input struct {
-	Metadata InputMetadata `json:"metadata"`
-	Models   []Model       `json:"models"`
-	Prompts  []Prompt      `json:"prompts"`
+	Metadata     InputMetadata `json:"metadata"`
+	SystemPrompt string        `json:"systemPrompt,omitempty"` // Default for models and sequences without one
+	Models       []Model       `json:"models"`
+	Prompts      []Prompt      `json:"prompts"`
+	Templates    *Templates    `json:"templates,omitempty"` // Expanded into prompts by ExpandTemplates
+	Examples     []Example     `json:"examples,omitempty"`  // Turns opening every sequence
+}
+
+// Templates describes prompt sequences generated from a set of records. Each record yields one
+// sequence made of the template prompts, with their {{field}} placeholders filled from the record.
+type Templates struct {
+	Prompts []Prompt         `json:"prompts"`           // Template prompts; sequence IDs are generated
+	Records []map[string]any `json:"records,omitempty"` // Inline records
+	Source  *RecordSource    `json:"source,omitempty"`  // File of records, read after the inline ones
+	IDField string           `json:"idField,omitempty"` // Field identifying each record; "id" by default
+}
+
+// RecordSource is a CSV or JSON Lines file of records.
+type RecordSource struct {
+	Path   string `json:"path"`
+	Format string `json:"format,omitempty"` // csv or jsonl; inferred from the file extension by default
 }
 
 // Define output structures
 type Response struct {
-	Provider       string     `json:"provider"`
-	Model          string     `json:"model"`
-	SequenceID     string     `json:"sequenceId"`
-	SequenceNumber int        `json:"sequenceNumber"`
-	ModelResponses []string   `json:"modelResponses"`
-	Error          *ErrorInfo `json:"error,omitempty"`
+	Provider       string            `json:"provider"`
+	Model          string            `json:"model"`
+	SequenceID     string            `json:"sequenceId"`
+	SequenceNumber int               `json:"sequenceNumber"`
+	RecordID       string            `json:"recordId,omitempty"`
+	ModelResponses []string          `json:"modelResponses"`
+	Skipped        bool              `json:"skipped,omitempty"` // The condition of the prompt did not hold
+	Error          *ErrorInfo        `json:"error,omitempty"`
+	Metadata       *ResponseMetadata `json:"metadata,omitempty"`
+}
+
+// ResponseMetadata describes how a response was obtained.
+type ResponseMetadata struct {
+	Attempts     []Attempt  `json:"attempts,omitempty"`
+	AnsweredBy   *ModelInfo `json:"answeredBy,omitempty"` // Set when the requested model has fallbacks
+	JSONRepaired bool       `json:"jsonRepaired,omitempty"`
+	Reasks       int        `json:"reasks,omitempty"` // Corrective follow-up turns sent
+}
+
+// ModelInfo identifies the model that produced a response.
+type ModelInfo struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+}
+
+// Attempt records one provider call made under a retry policy.
+type Attempt struct {
+	Attempt int        `json:"attempt"`
+	Error   *ErrorInfo `json:"error,omitempty"`
+	DelayMs int64      `json:"delayMs,omitempty"` // Backoff waited before the next attempt
 }
 
 type OutputMetadata struct {
@@ -49,8 +147,9 @@ type OutputMetadata struct {
 }
 
 type ErrorInfo struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Code     int    `json:"code"`
+	Category string `json:"category,omitempty"`
+	Message  string `json:"message"`
 }
 
 type Output struct {
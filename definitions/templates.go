@@ -0,0 +1,226 @@
+package definitions
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// placeholder matches a {{field}} placeholder of a prompt template.
+var placeholder = regexp.MustCompile(`\{\{\s*([^{}\s]+)\s*\}\}`)
+
+// stepReference matches the field of a {{stepN...}} reference to an earlier answer, which is
+// resolved while the sequence runs rather than from the record.
+var stepReference = regexp.MustCompile(`^step\d+([.\[]|$)`)
+
+// TemplateOption configures the expansion of prompt templates.
+type TemplateOption func(*templateConfig)
+
+// templateConfig holds the settings applied by the options of ExpandTemplates.
+type templateConfig struct {
+	recordDir string // Directory record source files are read from; none are read when empty
+}
+
+// WithRecordDir allows templates to read their records from CSV or JSON Lines files inside a
+// directory. Relative source paths are resolved from it, and paths leading outside of it,
+// including through symbolic links, are rejected. Without this option a record source fails
+// the expansion, so that inputs from untrusted clients cannot read local files.
+//
+// Parameters:
+//   - dir: The directory holding the record source files.
+//
+// Returns:
+//   - The option allowing record source files.
+func WithRecordDir(dir string) TemplateOption {
+	return func(c *templateConfig) {
+		c.recordDir = dir
+	}
+}
+
+// ExpandTemplates turns the templates of an input into prompts. Each record yields one sequence
+// holding a copy of every template prompt, with the {{field}} placeholders of its content and
+// system prompt replaced by the values of the record; {{stepN...}} references to earlier answers
+// are kept for the sequence to resolve. The sequence ID and record ID of the
+// generated prompts are the value of the ID field of the record, or its 1-based position among
+// all records when it has none, so they do not change between runs over the same records.
+//
+// Parameters:
+//   - input: The parsed input.
+//   - opts: Optional settings, such as WithRecordDir to read records from files.
+//
+// Returns:
+//   - The input with the generated prompts appended to its prompts and no templates.
+//   - An error if the record source is not allowed or cannot be read, a placeholder names a
+//     field missing from a record, or a generated sequence ID is already in use.
+func ExpandTemplates(input Input, opts ...TemplateOption) (Input, error) {
+	templates := input.Templates
+	if templates == nil {
+		return input, nil
+	}
+	var cfg templateConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	records := templates.Records
+	if templates.Source != nil {
+		loaded, err := loadRecords(*templates.Source, cfg.recordDir)
+		if err != nil {
+			return input, err
+		}
+		records = append(records[:len(records):len(records)], loaded...)
+	}
+	idField := cmp.Or(templates.IDField, "id")
+
+	used := make(map[string]bool)
+	for _, prompt := range input.Prompts {
+		used[prompt.SequenceID] = true
+	}
+
+	prompts := append([]Prompt{}, input.Prompts...)
+	for i, record := range records {
+		recordID := strconv.Itoa(i + 1)
+		if value, exists := record[idField]; exists {
+			recordID = fieldText(value)
+		}
+		if used[recordID] {
+			return input, fmt.Errorf("record %d: sequence ID %q is already in use", i+1, recordID)
+		}
+		used[recordID] = true
+
+		for _, template := range templates.Prompts {
+			prompt := template
+			prompt.SequenceID = recordID
+			prompt.RecordID = recordID
+			var err error
+			if prompt.PromptContent, err = fillPlaceholders(template.PromptContent, record); err != nil {
+				return input, fmt.Errorf("record %s: %w", recordID, err)
+			}
+			if prompt.SystemPrompt, err = fillPlaceholders(template.SystemPrompt, record); err != nil {
+				return input, fmt.Errorf("record %s: %w", recordID, err)
+			}
+			prompts = append(prompts, prompt)
+		}
+	}
+
+	input.Prompts = prompts
+	input.Templates = nil
+	return input, nil
+}
+
+// fillPlaceholders replaces the {{field}} placeholders of a template with the values of a record.
+// References to earlier answers are kept unless the record has a field of the same name.
+func fillPlaceholders(template string, record map[string]any) (string, error) {
+	var missing error
+	filled := placeholder.ReplaceAllStringFunc(template, func(match string) string {
+		field := placeholder.FindStringSubmatch(match)[1]
+		value, exists := record[field]
+		if !exists && stepReference.MatchString(field) {
+			return match
+		}
+		if !exists {
+			missing = cmp.Or(missing, fmt.Errorf("no field %q for placeholder %s", field, match))
+			return match
+		}
+		return fieldText(value)
+	})
+	return filled, missing
+}
+
+// fieldText renders a record value in a prompt: strings as they are, other values as JSON.
+func fieldText(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	}
+	text, _ := json.Marshal(value)
+	return string(text)
+}
+
+// loadRecords reads the records of a CSV or JSON Lines file inside dir. CSV files must start
+// with a header row naming the fields; every JSON Lines line must hold an object.
+func loadRecords(source RecordSource, dir string) ([]map[string]any, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("record source %s is not allowed: file sources must be enabled with a record directory", source.Path)
+	}
+	format := source.Format
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(source.Path)), ".")
+	}
+
+	name := source.Path
+	if filepath.IsAbs(name) {
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve record directory: %w", err)
+		}
+		if name, err = filepath.Rel(absDir, name); err != nil {
+			return nil, fmt.Errorf("record source %s is outside of the record directory", source.Path)
+		}
+	}
+	// OpenInRoot refuses names escaping the directory, through ".." or symbolic links.
+	file, err := os.OpenInRoot(dir, name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open record source: %w", err)
+	}
+	defer file.Close()
+
+	switch format {
+	case "csv":
+		return readCSVRecords(file, source.Path)
+	case "jsonl":
+		return readJSONLRecords(file, source.Path)
+	default:
+		return nil, fmt.Errorf("unsupported record source format %q for %s (expected csv or jsonl)", format, source.Path)
+	}
+}
+
+// readCSVRecords reads CSV records, taking the field names from the header row.
+func readCSVRecords(r io.Reader, path string) ([]map[string]any, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %w", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("cannot read %s: missing header row", path)
+	}
+
+	header := rows[0]
+	records := make([]map[string]any, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		record := make(map[string]any, len(header))
+		for i, field := range header {
+			record[field] = row[i]
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// readJSONLRecords reads one JSON object per line. Numbers keep their original text.
+func readJSONLRecords(r io.Reader, path string) ([]map[string]any, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	records := []map[string]any{}
+	for {
+		var record map[string]any
+		err := decoder.Decode(&record)
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read record %d of %s: %w", len(records)+1, path, err)
+		}
+		records = append(records, record)
+	}
+}
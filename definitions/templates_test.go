@@ -0,0 +1,174 @@
+package definitions
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandTemplates(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "papers.csv")
+	os.WriteFile(csvPath, []byte("doi,abstract\n10.1/a,\"Corals, reefs\"\n10.1/b,Mangroves\n"), 0644)
+	jsonlPath := filepath.Join(dir, "papers.jsonl")
+	os.WriteFile(jsonlPath, []byte("{\"id\": 12345678901234567, \"abstract\": \"Kelp\"}\n{\"abstract\": \"Seagrass\", \"year\": 2021}\n"), 0644)
+	xlsxPath := filepath.Join(dir, "papers.xlsx")
+	os.WriteFile(xlsxPath, []byte{}, 0644)
+	outside := filepath.Join(t.TempDir(), "secrets.csv")
+	os.WriteFile(outside, []byte("id,secret\n1,hunter2\n"), 0644)
+
+	templates := []Prompt{
+		{PromptContent: "Summarize: {{abstract}}", SequenceNumber: 1, ResponseFormat: "text"},
+		{PromptContent: "Extract the study sites of {{ abstract }}.", SequenceNumber: 2, SystemPrompt: "Record {{id}}"},
+	}
+
+	tests := []struct {
+		name          string
+		input         Input
+		expectIDs     []string
+		expectContent string
+		expectError   string
+	}{
+		{
+			name: "Inline records",
+			input: Input{Templates: &Templates{Prompts: templates[:1], Records: []map[string]any{
+				{"id": "w1", "abstract": "Corals"}, {"id": 7.0, "abstract": "Reefs"},
+			}}},
+			expectIDs:     []string{"w1", "7"},
+			expectContent: "Summarize: Corals",
+		},
+		{
+			name: "Records without identifier are numbered",
+			input: Input{Templates: &Templates{Prompts: templates[:1], Records: []map[string]any{
+				{"abstract": "Corals"}, {"abstract": "Reefs"},
+			}}},
+			expectIDs:     []string{"1", "2"},
+			expectContent: "Summarize: Corals",
+		},
+		{
+			name:          "CSV source with identifier field",
+			input:         Input{Templates: &Templates{Prompts: templates[:1], Source: &RecordSource{Path: csvPath}, IDField: "doi"}},
+			expectIDs:     []string{"10.1/a", "10.1/b"},
+			expectContent: "Summarize: Corals, reefs",
+		},
+		{
+			name: "Inline records followed by a JSON Lines source",
+			input: Input{Templates: &Templates{Prompts: templates[:1], Records: []map[string]any{{"id": "w1", "abstract": "Corals"}},
+				Source: &RecordSource{Path: jsonlPath}}},
+			expectIDs:     []string{"w1", "12345678901234567", "3"},
+			expectContent: "Summarize: Corals",
+		},
+		{
+			name:        "Missing field",
+			input:       Input{Templates: &Templates{Prompts: templates, Source: &RecordSource{Path: csvPath}, IDField: "doi"}},
+			expectError: `record 10.1/a: no field "id" for placeholder {{id}}`,
+		},
+		{
+			name: "Sequence ID in use",
+			input: Input{
+				Prompts:   []Prompt{{PromptContent: "Hello", SequenceID: "1", SequenceNumber: 1}},
+				Templates: &Templates{Prompts: templates[:1], Records: []map[string]any{{"abstract": "Corals"}}},
+			},
+			expectError: `record 1: sequence ID "1" is already in use`,
+		},
+		{
+			name:          "Source relative to the record directory",
+			input:         Input{Templates: &Templates{Prompts: templates[:1], Source: &RecordSource{Path: "papers.csv"}, IDField: "doi"}},
+			expectIDs:     []string{"10.1/a", "10.1/b"},
+			expectContent: "Summarize: Corals, reefs",
+		},
+		{
+			name:        "Absolute source outside the record directory",
+			input:       Input{Templates: &Templates{Prompts: templates[:1], Source: &RecordSource{Path: outside}}},
+			expectError: "cannot open record source",
+		},
+		{
+			name:        "Relative source escaping the record directory",
+			input:       Input{Templates: &Templates{Prompts: templates[:1], Source: &RecordSource{Path: filepath.Join("..", filepath.Base(filepath.Dir(outside)), "secrets.csv")}}},
+			expectError: "cannot open record source",
+		},
+		{
+			name:        "Unknown source format",
+			input:       Input{Templates: &Templates{Prompts: templates[:1], Source: &RecordSource{Path: xlsxPath}}},
+			expectError: `unsupported record source format "xlsx"`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			expanded, err := ExpandTemplates(tc.input, WithRecordDir(dir))
+			if tc.expectError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectError) {
+					t.Fatalf("expected error %q, got %v", tc.expectError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if expanded.Templates != nil {
+				t.Errorf("expected the templates to be consumed")
+			}
+
+			ids := []string{}
+			for _, prompt := range expanded.Prompts {
+				if prompt.SequenceID != prompt.RecordID {
+					t.Errorf("expected the record ID as sequence ID, got %+v", prompt)
+				}
+				ids = append(ids, prompt.SequenceID)
+			}
+			if strings.Join(ids, "|") != strings.Join(tc.expectIDs, "|") {
+				t.Errorf("expected sequence IDs %v, got %v", tc.expectIDs, ids)
+			}
+			if expanded.Prompts[0].PromptContent != tc.expectContent || expanded.Prompts[0].ResponseFormat != "text" {
+				t.Errorf("unexpected first prompt %+v", expanded.Prompts[0])
+			}
+		})
+	}
+}
+
+func TestExpandTemplatesRejectsSourcesByDefault(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "papers.csv")
+	os.WriteFile(path, []byte("id,abstract\n1,Corals\n"), 0644)
+
+	input := Input{Templates: &Templates{
+		Prompts: []Prompt{{PromptContent: "Summarize: {{abstract}}", SequenceNumber: 1}},
+		Source:  &RecordSource{Path: path},
+	}}
+	_, err := ExpandTemplates(input)
+	if err == nil || !strings.Contains(err.Error(), "is not allowed") {
+		t.Errorf("expected the record source to be rejected without a record directory, got %v", err)
+	}
+}
+
+func TestExpandTemplatesFillsEveryField(t *testing.T) {
+	input := Input{Templates: &Templates{
+		Prompts: []Prompt{
+			{PromptContent: "Summarize: {{abstract}}", SequenceNumber: 1},
+			{PromptContent: "Sites of {{ title }} ({{year}})?", SequenceNumber: 2, SystemPrompt: "You read {{title}}."},
+			{PromptContent: "Effect sizes of {{step2.sites}} in {{title}}?", SequenceNumber: 3},
+		},
+		Records: []map[string]any{{"id": "p1", "abstract": "Corals", "title": "Reefs", "year": 2021.0}},
+	}}
+
+	expanded, err := ExpandTemplates(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(expanded.Prompts) != 3 {
+		t.Fatalf("expected 2 prompts, got %+v", expanded.Prompts)
+	}
+	second := expanded.Prompts[1]
+	if second.PromptContent != "Sites of Reefs (2021)?" || second.SystemPrompt != "You read Reefs." || second.SequenceNumber != 2 {
+		t.Errorf("unexpected second prompt %+v", second)
+	}
+	// References to earlier answers are left for the sequence to resolve
+	if expanded.Prompts[2].PromptContent != "Effect sizes of {{step2.sites}} in Reefs?" {
+		t.Errorf("unexpected third prompt %+v", expanded.Prompts[2])
+	}
+	// The templates of the input are left untouched
+	if input.Templates.Prompts[1].PromptContent != "Sites of {{ title }} ({{year}})?" {
+		t.Errorf("templates were modified: %+v", input.Templates.Prompts)
+	}
+}
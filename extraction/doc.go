@@ -5,7 +5,12 @@ for responses using an abstracted query service.
 
 Core Functionality:
   - Extract: Main function that processes input JSON, sequences prompts, and queries the appropriate LLM.
+  - ExtractContext: Context-aware variant of Extract that stops in-flight calls and waits on cancellation.
+  - ExtractStream: Delivers each response to a callback as soon as its sequence finishes.
+  - ExtractJSONL: Reads prompts from and writes responses to JSON Lines streams with bounded memory.
+  - WithJournal: Option that checkpoints completed sequences in a journal file so interrupted runs can resume.
   - Ensures correct prompt sequencing before calling models.
+  - Runs sequences in parallel on worker pools bounded per provider/model, keeping output order deterministic.
   - Calls validation on the output to maintain schema integrity.
 
 Features:
@@ -0,0 +1,267 @@
+package extraction
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+	"github.com/open-and-sustainable/alembica/utils/logger"
+)
+
+const journalVersion = 1
+
+// journalHeader is the first line of a journal file and ties it to one input.
+type journalHeader struct {
+	Journal     string `json:"journal"`
+	Version     int    `json:"version"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// journalEntry records the responses of one completed (model, sequence) pair.
+type journalEntry struct {
+	Model      int                    `json:"model"`
+	Provider   string                 `json:"provider"`
+	ModelName  string                 `json:"modelName"`
+	SequenceID string                 `json:"sequenceId"`
+	Prompts    string                 `json:"prompts"`
+	Responses  []definitions.Response `json:"responses"`
+}
+
+// journalKey identifies a task across runs: the index of the model in the input and the sequence ID.
+type journalKey struct {
+	model      int
+	sequenceID string
+}
+
+// journalMark locates the entry of a task completed in an earlier run. Only the fingerprint of
+// its prompts is kept in memory; its responses are read back from the file when it is reused.
+type journalMark struct {
+	prompts string
+	offset  int64
+	length  int
+}
+
+// journal is an append-only JSON Lines file of completed tasks.
+type journal struct {
+	mu        sync.Mutex
+	file      *os.File
+	completed map[journalKey]journalMark // Tasks completed in earlier runs
+}
+
+// openJournal opens the journal at path, creating it if needed, and loads the tasks it records.
+// A truncated final line, left by a crash in the middle of a write, is discarded.
+//
+// Parameters:
+//   - path: The journal file path.
+//   - fingerprint: The fingerprint of the input being extracted.
+//
+// Returns:
+//   - The opened journal.
+//   - An error if the file cannot be read or written, or was written for a different input.
+func openJournal(path string, fingerprint string) (*journal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening journal: %w", err)
+	}
+
+	j := &journal{file: file, completed: make(map[journalKey]journalMark)}
+	validSize, err := j.load(fingerprint)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// Drop any partial line so new entries start on a line of their own
+	if err := file.Truncate(validSize); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error truncating journal: %w", err)
+	}
+	if _, err := file.Seek(validSize, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error seeking journal: %w", err)
+	}
+
+	if validSize == 0 {
+		if err := j.writeLine(journalHeader{Journal: "alembica", Version: journalVersion, Fingerprint: fingerprint}); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+// load reads the header and entries of the journal.
+//
+// Returns:
+//   - The size in bytes of the complete lines read.
+//   - An error if the journal is corrupted or belongs to a different input.
+func (j *journal) load(fingerprint string) (int64, error) {
+	reader := bufio.NewReader(j.file)
+	var validSize int64
+	lineNumber := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// Whatever follows the last newline is an interrupted write
+			return validSize, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("error reading journal: %w", err)
+		}
+		lineNumber++
+
+		if lineNumber == 1 {
+			var header journalHeader
+			if err := json.Unmarshal(line, &header); err != nil || header.Journal != "alembica" {
+				return 0, fmt.Errorf("not an alembica journal")
+			}
+			if header.Fingerprint != fingerprint {
+				return 0, fmt.Errorf("journal was written for a different input")
+			}
+		} else if len(bytes.TrimSpace(line)) > 0 {
+			var entry struct {
+				Model      int    `json:"model"`
+				SequenceID string `json:"sequenceId"`
+				Prompts    string `json:"prompts"`
+			}
+			if err := json.Unmarshal(line, &entry); err != nil {
+				return 0, fmt.Errorf("corrupted journal entry on line %d: %w", lineNumber, err)
+			}
+			j.completed[journalKey{entry.Model, entry.SequenceID}] = journalMark{prompts: entry.Prompts, offset: validSize, length: len(line)}
+		}
+		validSize += int64(len(line))
+	}
+}
+
+// lookup returns the recorded responses of a task, if it completed in an earlier run
+// with the same prompts. They are read back from the journal file and are not kept.
+func (j *journal) lookup(task extractionTask) ([]definitions.Response, bool) {
+	if j == nil {
+		return nil, false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	mark, exists := j.completed[journalKey{task.modelIndex, task.sequenceID}]
+	if !exists || mark.prompts != promptsFingerprint(task.prompts) {
+		return nil, false
+	}
+
+	line := make([]byte, mark.length)
+	var entry journalEntry
+	if _, err := j.file.ReadAt(line, mark.offset); err != nil {
+		logger.Error(fmt.Sprintf("error reading journal entry of sequence %s: %v", task.sequenceID, err))
+		return nil, false
+	}
+	if err := json.Unmarshal(line, &entry); err != nil {
+		logger.Error(fmt.Sprintf("error decoding journal entry of sequence %s: %v", task.sequenceID, err))
+		return nil, false
+	}
+	return entry.Responses, true
+}
+
+// record appends the responses of a completed task and flushes them to disk. They are not
+// kept in memory: a task completes once per run, so only earlier runs are looked up.
+func (j *journal) record(task extractionTask, responses []definitions.Response) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry := journalEntry{
+		Model:      task.modelIndex,
+		Provider:   task.model.Provider,
+		ModelName:  task.model.Model,
+		SequenceID: task.sequenceID,
+		Prompts:    promptsFingerprint(task.prompts),
+		Responses:  responses,
+	}
+	return j.writeLine(entry)
+}
+
+// writeLine appends one JSON line and syncs the file.
+func (j *journal) writeLine(value any) error {
+	line, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("error encoding journal entry: %w", err)
+	}
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("error syncing journal: %w", err)
+	}
+	return nil
+}
+
+// openJournal opens the journal configured by WithJournal for the input, or returns nil if none is set.
+func (c config) openJournal(input definitions.Input) (*journal, error) {
+	if c.journalPath == "" {
+		return nil, nil
+	}
+	j, err := openJournal(c.journalPath, inputFingerprint(input))
+	if err != nil {
+		logger.Error(fmt.Sprintf("error opening journal: %v", err))
+		return nil, err
+	}
+	return j, nil
+}
+
+// close closes the journal file.
+func (j *journal) close() error {
+	if j == nil {
+		return nil
+	}
+	return j.file.Close()
+}
+
+// inputFingerprint hashes the parts of the input that determine the output.
+// API keys and concurrency are left out, so rotating a key or changing the
+// parallelism does not invalidate a journal.
+//
+// Parameters:
+//   - input: The parsed extraction input.
+//
+// Returns:
+//   - The hex-encoded SHA-256 fingerprint.
+func inputFingerprint(input definitions.Input) string {
+	data, _ := json.Marshal(struct {
+		SchemaVersion string                `json:"schemaVersion"`
+		SystemPrompt  string                `json:"systemPrompt,omitempty"`
+		Examples      []definitions.Example `json:"examples,omitempty"`
+		Models        []definitions.Model   `json:"models"`
+		Prompts       []definitions.Prompt  `json:"prompts"`
+	}{input.Metadata.SchemaVersion, input.SystemPrompt, input.Examples, fingerprintModels(input.Models), input.Prompts})
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// fingerprintModels clears the model settings that do not change the results, such as
+// API keys and concurrency, including those of the fallbacks.
+func fingerprintModels(models []definitions.Model) []definitions.Model {
+	cleared := make([]definitions.Model, len(models))
+	for i, m := range models {
+		m.APIKey = ""
+		m.Concurrency = 0
+		m.Fallbacks = fingerprintModels(m.Fallbacks)
+		cleared[i] = m
+	}
+	return cleared
+}
+
+// promptsFingerprint hashes the prompts of a sequence, so a journal entry is only reused
+// for the prompts it answered. This matters for JSON Lines inputs, whose journal
+// fingerprint covers the header only.
+func promptsFingerprint(prompts []definitions.Prompt) string {
+	data, _ := json.Marshal(prompts)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,164 @@
+package extraction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+	"github.com/open-and-sustainable/alembica/llm/model"
+)
+
+// countingQueryService answers every prompt with its content and counts the sequences queried.
+// Sequences listed in fail are answered with an error instead.
+type countingQueryService struct {
+	mu      sync.Mutex
+	queried []string
+	fail    map[string]bool
+}
+
+func (cqs *countingQueryService) QueryLLM(ctx context.Context, prompts []definitions.Prompt, llm definitions.Model) ([]model.Answer, error) {
+	cqs.mu.Lock()
+	defer cqs.mu.Unlock()
+	cqs.queried = append(cqs.queried, prompts[0].PromptContent)
+	if cqs.fail[prompts[0].PromptContent] {
+		return nil, errors.New("simulated failure")
+	}
+	answers := []model.Answer{}
+	for _, prompt := range prompts {
+		answer := model.Answer{}
+		for sample := 1; sample <= max(llm.Samples, 1); sample++ {
+			answer.Responses = append(answer.Responses, fmt.Sprintf(`{"answer": %q, "sample": %d}`, prompt.PromptContent, sample))
+		}
+		answers = append(answers, answer)
+	}
+	return answers, nil
+}
+
+const journalInputJSON = `{
+	"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+	"models": [{"provider": "OpenAI", "model": "gpt-4o", "temperature": 0.7, "concurrency": 2}],
+	"prompts": [
+		{"promptContent": "a1", "sequenceId": "a", "sequenceNumber": 1},
+		{"promptContent": "a2", "sequenceId": "a", "sequenceNumber": 2},
+		{"promptContent": "b1", "sequenceId": "b", "sequenceNumber": 1},
+		{"promptContent": "c1", "sequenceId": "c", "sequenceNumber": 1}
+	]
+}`
+
+func withQueryService(t *testing.T, service *countingQueryService) {
+	t.Helper()
+	original := queryService
+	queryService = service
+	t.Cleanup(func() { queryService = original })
+}
+
+func TestExtractResumesFromJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.journal")
+
+	first := &countingQueryService{fail: map[string]bool{"b1": true}}
+	withQueryService(t, first)
+	if _, err := Extract(journalInputJSON, WithJournal(path)); err != nil {
+		t.Fatalf("first run failed: %v", err)
+	}
+	if len(first.queried) != 3 {
+		t.Fatalf("expected 3 sequences queried in the first run, got %v", first.queried)
+	}
+
+	// Simulate a crash in the middle of writing an entry
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("cannot open journal: %v", err)
+	}
+	file.WriteString(`{"model": 0, "sequenceId": "c", "respon`)
+	file.Close()
+
+	second := &countingQueryService{}
+	withQueryService(t, second)
+	resumed, err := Extract(journalInputJSON, WithJournal(path))
+	if err != nil {
+		t.Fatalf("resumed run failed: %v", err)
+	}
+	if len(second.queried) != 1 || second.queried[0] != "b1" {
+		t.Fatalf("expected only the failed sequence to be queried again, got %v", second.queried)
+	}
+
+	fresh := &countingQueryService{}
+	withQueryService(t, fresh)
+	expected, err := Extract(journalInputJSON)
+	if err != nil {
+		t.Fatalf("run without journal failed: %v", err)
+	}
+	if resumed != expected {
+		t.Errorf("resumed output differs from a complete run:\n%s\n%s", resumed, expected)
+	}
+
+	// A third run finds everything in the journal
+	third := &countingQueryService{}
+	withQueryService(t, third)
+	if _, err := Extract(journalInputJSON, WithJournal(path)); err != nil {
+		t.Fatalf("third run failed: %v", err)
+	}
+	if len(third.queried) != 0 {
+		t.Errorf("expected no queries once every sequence is journaled, got %v", third.queried)
+	}
+}
+
+func TestJournalKeepsOnlyEarlierRunsInMemory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	task := extractionTask{modelIndex: 0, model: definitions.Model{Provider: "OpenAI", Model: "gpt-4o"}, sequenceID: "a",
+		prompts: []definitions.Prompt{{PromptContent: "a1", SequenceID: "a", SequenceNumber: 1}}}
+	responses := []definitions.Response{{Provider: "OpenAI", Model: "gpt-4o", SequenceID: "a", SequenceNumber: 1, ModelResponses: []string{`{"ok": true}`}}}
+
+	j, err := openJournal(path, "input")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := j.record(task, responses); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(j.completed) != 0 {
+		t.Errorf("expected recorded tasks not to be kept in memory, got %d", len(j.completed))
+	}
+	j.close()
+
+	j, err = openJournal(path, "input")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer j.close()
+	recorded, done := j.lookup(task)
+	if !done || len(recorded) != 1 || recorded[0].ModelResponses[0] != `{"ok": true}` {
+		t.Errorf("expected the responses read back from the journal, got %+v (%v)", recorded, done)
+	}
+	task.prompts[0].PromptContent = "changed"
+	if _, done := j.lookup(task); done {
+		t.Errorf("expected changed prompts not to be reused")
+	}
+}
+
+func TestExtractRejectsJournalOfDifferentInput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.journal")
+	withQueryService(t, &countingQueryService{})
+
+	if _, err := Extract(journalInputJSON, WithJournal(path)); err != nil {
+		t.Fatalf("first run failed: %v", err)
+	}
+
+	changed := strings.Replace(journalInputJSON, `"temperature": 0.7`, `"temperature": 0.2`, 1)
+	_, err := Extract(changed, WithJournal(path))
+	if err == nil || !strings.Contains(err.Error(), "different input") {
+		t.Errorf("expected a journal mismatch error, got %v", err)
+	}
+
+	// API keys and concurrency do not change the results, so the journal still applies
+	rekeyed := strings.Replace(journalInputJSON, `"concurrency": 2`, `"concurrency": 4, "api_key": "new-key"`, 1)
+	if _, err := Extract(rekeyed, WithJournal(path)); err != nil {
+		t.Errorf("expected the journal to accept a new API key and concurrency, got %v", err)
+	}
+}
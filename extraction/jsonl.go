@@ -0,0 +1,224 @@
+package extraction
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+	"github.com/open-and-sustainable/alembica/utils/logger"
+	"github.com/open-and-sustainable/alembica/validation"
+)
+
+// jsonlInputHeader is the first line of a JSON Lines input.
+type jsonlInputHeader struct {
+	Metadata     definitions.InputMetadata `json:"metadata"`
+	SystemPrompt string                    `json:"systemPrompt,omitempty"`
+	Models       []definitions.Model       `json:"models"`
+	Templates    *definitions.Templates    `json:"templates,omitempty"`
+	Examples     []definitions.Example     `json:"examples,omitempty"`
+}
+
+// jsonlOutputHeader is the first line of a JSON Lines output.
+type jsonlOutputHeader struct {
+	Metadata definitions.OutputMetadata `json:"metadata"`
+}
+
+// ExtractJSONL processes a JSON Lines input and writes a JSON Lines output, keeping memory
+// bounded by the batch size rather than by the size of the corpus.
+//
+// The first input line holds the metadata and models; every following line holds one prompt.
+// The prompts of a sequence must be on consecutive lines. The first output line holds the
+// output metadata; every following line holds one response. Each line is validated on its own
+// against the schema of the input version. Sequences are read in batches (see WithBatchSize);
+// each batch runs like Extract and its responses are written ordered by model, then by sequence.
+//
+// Parameters:
+//   - ctx: The context controlling cancellation and deadlines of the extraction.
+//   - r: The JSON Lines input.
+//   - w: The destination of the JSON Lines output.
+//   - opts: Optional settings, such as WithJournal and WithBatchSize.
+//
+// Returns:
+//   - An error if a line is invalid, reading or writing fails, or ctx is done.
+//     Responses of the batches completed before the error have already been written.
+func ExtractJSONL(ctx context.Context, r io.Reader, w io.Writer, opts ...Option) error {
+	cfg := newConfig(opts)
+	lines := &lineReader{reader: bufio.NewReader(r)}
+
+	headerLine, err := lines.next()
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("empty JSON Lines input")
+	}
+	if err != nil {
+		return err
+	}
+	var header jsonlInputHeader
+	if err := json.Unmarshal(headerLine, &header); err != nil {
+		logger.Error(fmt.Sprintf("error parsing JSON Lines header: %v", err))
+		return fmt.Errorf("line %d: %w", lines.number, err)
+	}
+	schemaVersion := header.Metadata.SchemaVersion
+	if err := validation.ValidateInputHeader(string(headerLine), schemaVersion); err != nil {
+		logger.Error(fmt.Sprintf("error validating JSON Lines header: %v", err))
+		return fmt.Errorf("line %d: %w", lines.number, err)
+	}
+	if header.Templates != nil {
+		return fmt.Errorf("line %d: prompt templates are not supported in JSON Lines inputs", lines.number)
+	}
+	if err := checkModels(header.Models); err != nil {
+		logger.Error(err.Error())
+		return fmt.Errorf("line %d: %w", lines.number, err)
+	}
+
+	j, err := cfg.openJournal(definitions.Input{Metadata: header.Metadata, SystemPrompt: header.SystemPrompt, Models: header.Models, Examples: header.Examples})
+	if err != nil {
+		return err
+	}
+	defer j.close()
+
+	output := bufio.NewWriter(w)
+	if err := writeLine(output, jsonlOutputHeader{Metadata: definitions.OutputMetadata{SchemaVersion: schemaVersion}}); err != nil {
+		return err
+	}
+
+	batch := []definitions.Prompt{}
+	batchSequences := 0
+	seen := make(map[string]bool)
+	currentSequence := ""
+	for {
+		line, err := lines.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := validation.ValidateInputLine(string(line), schemaVersion); err != nil {
+			logger.Error(fmt.Sprintf("error validating JSON Lines prompt on line %d: %v", lines.number, err))
+			return fmt.Errorf("line %d: %w", lines.number, err)
+		}
+		var prompt definitions.Prompt
+		if err := json.Unmarshal(line, &prompt); err != nil {
+			return fmt.Errorf("line %d: %w", lines.number, err)
+		}
+
+		if prompt.SequenceID != currentSequence || batchSequences == 0 {
+			if seen[prompt.SequenceID] {
+				return fmt.Errorf("line %d: prompts of sequence %s are not on consecutive lines", lines.number, prompt.SequenceID)
+			}
+			if batchSequences == cfg.batchSize {
+				if err := extractBatch(ctx, header, batch, j, output); err != nil {
+					return err
+				}
+				batch = batch[:0]
+				batchSequences = 0
+			}
+			seen[prompt.SequenceID] = true
+			currentSequence = prompt.SequenceID
+			batchSequences++
+		}
+		batch = append(batch, prompt)
+	}
+
+	if len(batch) > 0 {
+		return extractBatch(ctx, header, batch, j, output)
+	}
+	return output.Flush()
+}
+
+// extractBatch runs the sequences of one batch and writes their responses.
+//
+// Parameters:
+//   - ctx: The context controlling cancellation of the extraction.
+//   - header: The metadata and models of the input.
+//   - prompts: The prompts of the batch.
+//   - j: The journal of completed tasks, or nil.
+//   - output: The destination of the response lines; it is flushed after the batch.
+//
+// Returns:
+//   - An error if a sequence sets different system prompts or has an invalid condition, the
+//     extraction is interrupted, or a response cannot be validated or written.
+func extractBatch(ctx context.Context, header jsonlInputHeader, prompts []definitions.Prompt, j *journal, output *bufio.Writer) error {
+	if err := checkSequences(prompts); err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+	tasks := planTasks(header.Models, prompts, header.SystemPrompt, header.Examples)
+	results := make([][]definitions.Response, len(tasks))
+	err := runTasks(ctx, tasks, j, func(i int, responses []definitions.Response) error {
+		results[i] = responses
+		return nil
+	})
+	if err != nil {
+		logger.Error(fmt.Sprintf("extraction interrupted: %v", err))
+		return err
+	}
+
+	for _, responses := range results {
+		for _, response := range responses {
+			responseJSON, err := json.Marshal(response)
+			if err != nil {
+				return fmt.Errorf("error generating output JSON: %v", err)
+			}
+			if err := validation.ValidateOutputLine(string(responseJSON), header.Metadata.SchemaVersion); err != nil {
+				logger.Error(fmt.Sprintf("error validating response of sequence %s: %v", response.SequenceID, err))
+				return err
+			}
+			if err := writeRaw(output, responseJSON); err != nil {
+				return err
+			}
+		}
+	}
+	return output.Flush()
+}
+
+// lineReader reads the non-blank lines of a JSON Lines stream, whatever their length.
+type lineReader struct {
+	reader *bufio.Reader
+	number int
+}
+
+// next returns the next non-blank line, or io.EOF at the end of the stream.
+func (lr *lineReader) next() ([]byte, error) {
+	for {
+		line, err := lr.reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("error reading JSON Lines input: %w", err)
+		}
+		if len(line) > 0 {
+			lr.number++
+		}
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			return trimmed, nil
+		}
+		if err != nil {
+			return nil, io.EOF
+		}
+	}
+}
+
+// writeLine encodes value as one JSON line.
+func writeLine(output *bufio.Writer, value any) error {
+	line, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("error generating output JSON: %v", err)
+	}
+	return writeRaw(output, line)
+}
+
+// writeRaw writes an encoded JSON value followed by a newline.
+func writeRaw(output *bufio.Writer, line []byte) error {
+	if _, err := output.Write(line); err != nil {
+		return fmt.Errorf("error writing JSON Lines output: %w", err)
+	}
+	if err := output.WriteByte('\n'); err != nil {
+		return fmt.Errorf("error writing JSON Lines output: %w", err)
+	}
+	return nil
+}
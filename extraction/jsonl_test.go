@@ -0,0 +1,99 @@
+package extraction
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+)
+
+const jsonlInput = `{"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"}, "models": [{"provider": "OpenAI", "model": "gpt-4o", "temperature": 0.7, "concurrency": 2}, {"provider": "OpenAI", "model": "gpt-4o-mini", "temperature": 0.7}]}
+{"promptContent": "a1", "sequenceId": "a", "sequenceNumber": 1}
+{"promptContent": "a2", "sequenceId": "a", "sequenceNumber": 2}
+
+{"promptContent": "b1", "sequenceId": "b", "sequenceNumber": 1}
+{"promptContent": "c1", "sequenceId": "c", "sequenceNumber": 1}
+`
+
+func TestExtractJSONL(t *testing.T) {
+	withQueryService(t, &countingQueryService{fail: map[string]bool{"c1": true}})
+
+	var output bytes.Buffer
+	if err := ExtractJSONL(context.Background(), strings.NewReader(jsonlInput), &output, WithBatchSize(2)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 9 {
+		t.Fatalf("expected a header and 8 responses, got %d lines:\n%s", len(lines), output.String())
+	}
+
+	var header jsonlOutputHeader
+	if err := json.Unmarshal([]byte(lines[0]), &header); err != nil || header.Metadata.SchemaVersion != "v2" {
+		t.Errorf("unexpected output header: %s", lines[0])
+	}
+
+	// Batches of two sequences: a and b for both models, then c for both models
+	expected := []string{"gpt-4o/a/1", "gpt-4o/a/2", "gpt-4o/b/1", "gpt-4o-mini/a/1", "gpt-4o-mini/a/2", "gpt-4o-mini/b/1", "gpt-4o/c/1", "gpt-4o-mini/c/1"}
+	for i, line := range lines[1:] {
+		var response definitions.Response
+		if err := json.Unmarshal([]byte(line), &response); err != nil {
+			t.Fatalf("invalid response line %q: %v", line, err)
+		}
+		got := fmt.Sprintf("%s/%s/%d", response.Model, response.SequenceID, response.SequenceNumber)
+		if got != expected[i] {
+			t.Errorf("line %d: expected %s, got %s", i+2, expected[i], got)
+		}
+		if (response.SequenceID == "c") != (response.Error != nil) {
+			t.Errorf("line %d: unexpected error state %+v", i+2, response.Error)
+		}
+	}
+}
+
+func TestExtractJSONLInvalidInput(t *testing.T) {
+	withQueryService(t, &countingQueryService{})
+
+	header := strings.SplitN(jsonlInput, "\n", 2)[0]
+	tests := []struct {
+		name     string
+		input    string
+		errorMsg string
+	}{
+		{
+			name:     "Empty input",
+			input:    "\n\n",
+			errorMsg: "empty JSON Lines input",
+		},
+		{
+			name:     "Header with prompts",
+			input:    `{"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"}, "models": [], "prompts": []}`,
+			errorMsg: "line 1: validation errors: header line must not contain prompts",
+		},
+		{
+			name:     "Prompt missing sequence number",
+			input:    header + "\n" + `{"promptContent": "a1", "sequenceId": "a"}`,
+			errorMsg: "line 2: validation errors: (root): sequenceNumber is required",
+		},
+		{
+			name: "Sequence split across the file",
+			input: header + "\n" +
+				`{"promptContent": "a1", "sequenceId": "a", "sequenceNumber": 1}` + "\n" +
+				`{"promptContent": "b1", "sequenceId": "b", "sequenceNumber": 1}` + "\n" +
+				`{"promptContent": "a2", "sequenceId": "a", "sequenceNumber": 2}`,
+			errorMsg: "line 4: prompts of sequence a are not on consecutive lines",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ExtractJSONL(context.Background(), strings.NewReader(tc.input), &bytes.Buffer{})
+			if err == nil || err.Error() != tc.errorMsg {
+				t.Errorf("expected error %q, got %v", tc.errorMsg, err)
+			}
+		})
+	}
+}
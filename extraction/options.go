@@ -0,0 +1,80 @@
+package extraction
+
+import "github.com/open-and-sustainable/alembica/definitions"
+
+// Option configures an extraction run.
+type Option func(*config)
+
+// config holds the settings applied by the options of an extraction run.
+type config struct {
+	journalPath string
+	batchSize   int
+	recordDir   string
+}
+
+// defaultBatchSize is the number of sequences ExtractJSONL reads before running them.
+const defaultBatchSize = 256
+
+// WithJournal checkpoints the extraction in a journal file.
+// Each (model, sequence) pair that completes without error is appended to the file
+// as soon as it finishes. Re-running the same input with the same journal skips the
+// recorded pairs and produces the same output; failed pairs are queried again.
+//
+// Parameters:
+//   - path: The journal file path; it is created if it does not exist.
+//
+// Returns:
+//   - The option enabling the journal.
+func WithJournal(path string) Option {
+	return func(c *config) {
+		c.journalPath = path
+	}
+}
+
+// WithBatchSize sets how many sequences ExtractJSONL reads and runs at a time.
+// Larger batches keep more workers busy; smaller ones use less memory.
+// Values below one are ignored.
+//
+// Parameters:
+//   - sequences: The number of sequences per batch.
+//
+// Returns:
+//   - The option setting the batch size.
+func WithBatchSize(sequences int) Option {
+	return func(c *config) {
+		if sequences > 0 {
+			c.batchSize = sequences
+		}
+	}
+}
+
+// WithRecordDir allows prompt templates to read their records from CSV or JSON Lines files
+// inside a directory. Without it, inputs whose templates name a record source are rejected.
+//
+// Parameters:
+//   - dir: The directory holding the record source files; relative paths are resolved from it.
+//
+// Returns:
+//   - The option allowing record source files.
+func WithRecordDir(dir string) Option {
+	return func(c *config) {
+		c.recordDir = dir
+	}
+}
+
+// templateOptions returns the options for expanding the prompt templates of the input.
+func (c config) templateOptions() []definitions.TemplateOption {
+	if c.recordDir == "" {
+		return nil
+	}
+	return []definitions.TemplateOption{definitions.WithRecordDir(c.recordDir)}
+}
+
+// newConfig applies the options to the default settings.
+func newConfig(opts []Option) config {
+	c := config{batchSize: defaultBatchSize}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
@@ -0,0 +1,80 @@
+package extraction
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+	"github.com/open-and-sustainable/alembica/utils/logger"
+	"github.com/open-and-sustainable/alembica/validation"
+)
+
+// ExtractStream processes input JSON, queries LLMs, and delivers each response to handler
+// as soon as its sequence finishes, instead of returning one output document at the end.
+//
+// Responses arrive in completion order; within a sequence they keep prompt order. The handler
+// is never called concurrently, so it may write to a database or update progress without
+// locking. If the handler returns an error, the extraction stops and ExtractStream returns
+// that error. Each response is validated against the output schema before delivery.
+//
+// Parameters:
+//   - ctx: The context controlling cancellation and deadlines of the extraction.
+//   - inputJSON: JSON string containing metadata, models, and prompts.
+//   - handler: Function receiving each response.
+//   - opts: Optional settings, such as WithJournal.
+//
+// Returns:
+//   - An error if the input cannot be parsed, a response fails validation, the handler fails, or ctx is done.
+func ExtractStream(ctx context.Context, inputJSON string, handler func(definitions.Response) error, opts ...Option) error {
+	cfg := newConfig(opts)
+
+	var inputData definitions.Input
+	if err := json.Unmarshal([]byte(inputJSON), &inputData); err != nil {
+		logger.Error(fmt.Sprintf("error parsing input JSON: %v", err))
+		return err
+	}
+	inputData, err := definitions.ExpandTemplates(inputData, cfg.templateOptions()...)
+	if err != nil {
+		logger.Error(fmt.Sprintf("error expanding prompt templates: %v", err))
+		return err
+	}
+	if err := checkModels(inputData.Models); err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+	if err := checkSequences(inputData.Prompts); err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	j, err := cfg.openJournal(inputData)
+	if err != nil {
+		return err
+	}
+	defer j.close()
+
+	schemaVersion := inputData.Metadata.SchemaVersion
+	tasks := planTasks(inputData.Models, inputData.Prompts, inputData.SystemPrompt, inputData.Examples)
+	err = runTasks(ctx, tasks, j, func(_ int, responses []definitions.Response) error {
+		for _, response := range responses {
+			responseJSON, err := json.Marshal(response)
+			if err != nil {
+				return fmt.Errorf("error generating output JSON: %v", err)
+			}
+			if err := validation.ValidateOutputLine(string(responseJSON), schemaVersion); err != nil {
+				logger.Error(fmt.Sprintf("error validating response of sequence %s: %v", response.SequenceID, err))
+				return err
+			}
+			if err := handler(response); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Error(fmt.Sprintf("extraction interrupted: %v", err))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package extraction
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+)
+
+func TestExtractStreamDeliversEveryResponse(t *testing.T) {
+	withQueryService(t, &countingQueryService{fail: map[string]bool{"b1": true}})
+
+	streamed := map[string][]definitions.Response{}
+	err := ExtractStream(context.Background(), journalInputJSON, func(response definitions.Response) error {
+		streamed[response.SequenceID] = append(streamed[response.SequenceID], response)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	outputJSON, err := Extract(journalInputJSON)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var output definitions.Output
+	if err := json.Unmarshal([]byte(outputJSON), &output); err != nil {
+		t.Fatalf("invalid output JSON: %v", err)
+	}
+
+	// Sequences may complete in any order, but each one matches the batch output
+	count := 0
+	for _, expected := range output.Responses {
+		responses := streamed[expected.SequenceID]
+		index := expected.SequenceNumber - 1
+		if index >= len(responses) || responses[index].SequenceNumber != expected.SequenceNumber {
+			t.Errorf("missing streamed response for %s/%d", expected.SequenceID, expected.SequenceNumber)
+			continue
+		}
+		if (responses[index].Error == nil) != (expected.Error == nil) {
+			t.Errorf("streamed response for %s/%d differs from batch output", expected.SequenceID, expected.SequenceNumber)
+		}
+		count++
+	}
+	if total := len(streamed["a"]) + len(streamed["b"]) + len(streamed["c"]); total != count {
+		t.Errorf("expected %d streamed responses, got %d", count, total)
+	}
+}
+
+func TestExtractStreamStopsOnHandlerError(t *testing.T) {
+	service := &countingQueryService{}
+	withQueryService(t, service)
+
+	stop := errors.New("database unavailable")
+	calls := 0
+	err := ExtractStream(context.Background(), journalInputJSON, func(response definitions.Response) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Fatalf("expected the handler error, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected delivery to stop after the failing call, got %d calls", calls)
+	}
+}
@@ -1,9 +1,13 @@
 package extraction
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
 	"sort"
+	"sync"
 
 	"github.com/open-and-sustainable/alembica/definitions"
 	"github.com/open-and-sustainable/alembica/llm/model"
@@ -11,20 +15,56 @@ import (
 	"github.com/open-and-sustainable/alembica/validation"
 )
 
+// Global QueryService used to send prompt sequences to the models.
+var queryService model.QueryService = model.DefaultQueryService{}
+
 // Extract processes input JSON, queries LLMs, and returns structured responses.
+// It is equivalent to ExtractContext with a background context.
 //
 // Parameters:
 //   - inputJSON: JSON string containing metadata, models, and prompts.
+//   - opts: Optional settings, such as WithJournal.
 //
 // Returns:
 //   - A JSON string with responses from the models, or an error if processing fails.
-func Extract(inputJSON string) (string, error) {
+func Extract(inputJSON string, opts ...Option) (string, error) {
+	return ExtractContext(context.Background(), inputJSON, opts...)
+}
+
+// ExtractContext processes input JSON, queries LLMs, and returns structured responses.
+// Sequences run in parallel on worker pools bounded per provider/model by the models'
+// concurrency setting; responses are always ordered by model, then by sequence.
+// Cancelling ctx, or letting its deadline expire, stops in-flight provider calls and
+// rate-limit waits and makes ExtractContext return the context error.
+//
+// Parameters:
+//   - ctx: The context controlling cancellation and deadlines of the extraction.
+//   - inputJSON: JSON string containing metadata, models, and prompts.
+//   - opts: Optional settings, such as WithJournal.
+//
+// Returns:
+//   - A JSON string with responses from the models, or an error if processing fails.
+func ExtractContext(ctx context.Context, inputJSON string, opts ...Option) (string, error) {
+	cfg := newConfig(opts)
+
 	var inputData definitions.Input
 	err := json.Unmarshal([]byte(inputJSON), &inputData)
 	if err != nil {
 		logger.Error(fmt.Sprintf("error parsing input JSON: %v", err))
 		return "", err
 	}
+	if inputData, err = definitions.ExpandTemplates(inputData, cfg.templateOptions()...); err != nil {
+		logger.Error(fmt.Sprintf("error expanding prompt templates: %v", err))
+		return "", err
+	}
+	if err := checkModels(inputData.Models); err != nil {
+		logger.Error(err.Error())
+		return "", err
+	}
+	if err := checkSequences(inputData.Prompts); err != nil {
+		logger.Error(err.Error())
+		return "", err
+	}
 
 	outputData := definitions.Output{
 		Metadata: definitions.OutputMetadata{
@@ -33,10 +73,150 @@ func Extract(inputJSON string) (string, error) {
 		Responses: []definitions.Response{},
 	}
 
+	j, err := cfg.openJournal(inputData)
+	if err != nil {
+		return "", err
+	}
+	defer j.close()
+
+	// Results are stored by task index so the output order does not depend on
+	// which worker finishes first.
+	tasks := planTasks(inputData.Models, inputData.Prompts, inputData.SystemPrompt, inputData.Examples)
+	results := make([][]definitions.Response, len(tasks))
+	err = runTasks(ctx, tasks, j, func(i int, responses []definitions.Response) error {
+		results[i] = responses
+		return nil
+	})
+	if err != nil {
+		logger.Error(fmt.Sprintf("extraction interrupted: %v", err))
+		return "", err
+	}
+	for _, responses := range results {
+		outputData.Responses = append(outputData.Responses, responses...)
+	}
+
+	outputJSON, err := json.Marshal(outputData)
+	if err != nil {
+		logger.Error(fmt.Sprintf("error generating output JSON: %v", err))
+		return "", fmt.Errorf("error generating output JSON: %v", err)
+	}
+
+	if err := validation.ValidateOutput(string(outputJSON), inputData.Metadata.SchemaVersion); err != nil {
+		logger.Error(fmt.Sprintf("error validating output JSON: %v", err))
+		return "", err
+	}
+
+	return string(outputJSON), nil
+}
+
+// checkModels rejects models, or fallbacks of models, that set generation parameters their
+// provider does not accept, before any provider is queried.
+//
+// Parameters:
+//   - models: The models of the input.
+//
+// Returns:
+//   - An error naming the model and the unsupported parameters, or nil.
+func checkModels(models []definitions.Model) error {
+	for i, m := range models {
+		if err := model.ValidateParameters(m); err != nil {
+			return fmt.Errorf("model %d (%s): %w", i, m.Model, err)
+		}
+		for j, fallback := range m.Fallbacks {
+			if err := model.ValidateParameters(fallback); err != nil {
+				return fmt.Errorf("model %d (%s), fallback %d (%s): %w", i, m.Model, j, fallback.Model, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkSequences rejects sequences that could not run on any model, before any provider is
+// queried: sequences setting conflicting system prompts (see checkSystemPrompts) and sequences
+// with invalid conditions.
+//
+// Parameters:
+//   - prompts: The prompts of the input.
+//
+// Returns:
+//   - An error naming the sequence and the problem, or nil.
+func checkSequences(prompts []definitions.Prompt) error {
+	if err := checkSystemPrompts(prompts); err != nil {
+		return err
+	}
+
 	promptsBySequence := make(map[string][]definitions.Prompt)
 	sequenceIDs := []string{}
+	for _, prompt := range prompts {
+		if _, exists := promptsBySequence[prompt.SequenceID]; !exists {
+			sequenceIDs = append(sequenceIDs, prompt.SequenceID)
+		}
+		promptsBySequence[prompt.SequenceID] = append(promptsBySequence[prompt.SequenceID], prompt)
+	}
+	for _, sequenceID := range sequenceIDs {
+		sequence := promptsBySequence[sequenceID]
+		sort.SliceStable(sequence, func(i, j int) bool {
+			return sequence[i].SequenceNumber < sequence[j].SequenceNumber
+		})
+		if err := model.ValidateConditions(sequence); err != nil {
+			return fmt.Errorf("sequence %s: %w", sequenceID, err)
+		}
+	}
+	return nil
+}
+
+// checkSystemPrompts rejects sequences whose prompts set different system prompts, since a
+// sequence is answered in a single conversation under one system prompt.
+//
+// Parameters:
+//   - prompts: The prompts of the input.
+//
+// Returns:
+//   - An error naming the sequence and the prompts setting conflicting system prompts, or nil.
+func checkSystemPrompts(prompts []definitions.Prompt) error {
+	first := make(map[string]definitions.Prompt) // First prompt setting a system prompt, by sequence ID
+	for _, prompt := range prompts {
+		if prompt.SystemPrompt == "" {
+			continue
+		}
+		setter, exists := first[prompt.SequenceID]
+		if !exists {
+			first[prompt.SequenceID] = prompt
+			continue
+		}
+		if setter.SystemPrompt != prompt.SystemPrompt {
+			return fmt.Errorf("sequence %s: prompts %d and %d set different system prompts", prompt.SequenceID, setter.SequenceNumber, prompt.SequenceNumber)
+		}
+	}
+	return nil
+}
 
-	for _, prompt := range inputData.Prompts {
+// extractionTask is a prompt sequence to run against one model.
+type extractionTask struct {
+	modelIndex int
+	model      definitions.Model
+	sequenceID string
+	prompts    []definitions.Prompt
+}
+
+// planTasks groups the prompts into sequences and builds one task per (model, sequence) pair,
+// ordered by model, then by the first appearance of each sequence. The model of each task
+// carries the system prompt resolved for its sequence (see withSystemPrompt), and the examples
+// of the input open each sequence, before those of its first prompt.
+//
+// Parameters:
+//   - models: The models to query.
+//   - prompts: The prompts, in any order within their sequences.
+//   - systemPrompt: The system prompt of the input, or empty.
+//   - examples: The examples of the input, or nil.
+//
+// Returns:
+//   - The tasks, with prompts sorted by sequence number.
+func planTasks(models []definitions.Model, prompts []definitions.Prompt, systemPrompt string, examples []definitions.Example) []extractionTask {
+	promptsBySequence := make(map[string][]definitions.Prompt)
+	sequenceIDs := []string{}
+
+	for _, prompt := range prompts {
 		if _, exists := promptsBySequence[prompt.SequenceID]; !exists {
 			sequenceIDs = append(sequenceIDs, prompt.SequenceID)
 		}
@@ -48,54 +228,262 @@ func Extract(inputJSON string) (string, error) {
 		sort.SliceStable(promptsBySequence[seqID], func(i, j int) bool {
 			return promptsBySequence[seqID][i].SequenceNumber < promptsBySequence[seqID][j].SequenceNumber
 		})
+		if len(examples) > 0 {
+			first := &promptsBySequence[seqID][0]
+			first.Examples = append(slices.Clone(examples), first.Examples...)
+		}
 	}
 
-	queryService := model.DefaultQueryService{}
-
-	for _, modelInstance := range inputData.Models {
+	tasks := []extractionTask{}
+	for modelIndex, modelInstance := range models {
 		for _, sequenceID := range sequenceIDs {
-			prompts := promptsBySequence[sequenceID]
+			tasks = append(tasks, extractionTask{
+				modelIndex: modelIndex,
+				model:      withSystemPrompt(modelInstance, sequenceSystemPrompt(promptsBySequence[sequenceID]), systemPrompt),
+				sequenceID: sequenceID,
+				prompts:    promptsBySequence[sequenceID],
+			})
+		}
+	}
+	return tasks
+}
 
-			// Extract all prompt contents in correct sequence order
-			var promptContents []string
-			for _, p := range prompts {
-				promptContents = append(promptContents, p.PromptContent)
-			}
+// sequenceSystemPrompt returns the system prompt set on the prompts of a sequence, or empty if
+// none is. Sequences setting different ones are rejected beforehand by checkSystemPrompts.
+func sequenceSystemPrompt(prompts []definitions.Prompt) string {
+	for _, prompt := range prompts {
+		if prompt.SystemPrompt != "" {
+			return prompt.SystemPrompt
+		}
+	}
+	return ""
+}
 
-			// Query the model with all prompts in the sequence at once
-			responses, err := queryService.QueryLLM(promptContents, modelInstance)
-			if err != nil {
-				logger.Error(fmt.Sprintf("error querying LLM: %v", err))
-				continue
-			}
+// withSystemPrompt sets the system prompt a model uses for a sequence. The most specific one
+// applies: the system prompt of the sequence, then that of the model, then that of the input.
+// The fallbacks of the model are resolved the same way, each against its own system prompt.
+//
+// Parameters:
+//   - llm: The model to query.
+//   - sequencePrompt: The system prompt of the sequence, or empty.
+//   - inputPrompt: The system prompt of the input, or empty.
+//
+// Returns:
+//   - A copy of the model with the resolved system prompt.
+func withSystemPrompt(llm definitions.Model, sequencePrompt string, inputPrompt string) definitions.Model {
+	switch {
+	case sequencePrompt != "":
+		llm.SystemPrompt = sequencePrompt
+	case llm.SystemPrompt == "":
+		llm.SystemPrompt = inputPrompt
+	}
+	if len(llm.Fallbacks) > 0 {
+		fallbacks := make([]definitions.Model, len(llm.Fallbacks))
+		for i, fallback := range llm.Fallbacks {
+			fallbacks[i] = withSystemPrompt(fallback, sequencePrompt, inputPrompt)
+		}
+		llm.Fallbacks = fallbacks
+	}
+	return llm
+}
 
-			// Process responses (they should be in the same order as prompts)
-			for i, p := range prompts {
-				if i < len(responses) {
-					outputResponse := definitions.Response{
-						Provider:       modelInstance.Provider,
-						Model:          modelInstance.Model,
-						SequenceID:     sequenceID,
-						SequenceNumber: p.SequenceNumber,
-						ModelResponses: []string{responses[i]}, // Ensure this matches your structure
-					}
+// runTasks runs the tasks on worker pools bounded per provider/model.
+// Each pool has as many workers as the highest concurrency configured for its
+// provider/model (at least one), and all workers of a pool share its rate limiter.
+// Tasks recorded in the journal are not run again, and tasks completing without
+// error are added to it.
+//
+// Parameters:
+//   - ctx: The context controlling cancellation of the extraction.
+//   - tasks: The sequences to run.
+//   - j: The journal of completed tasks, or nil.
+//   - deliver: Called with the task index and responses as each task completes, in
+//     completion order and never concurrently. An error stops the run.
+//
+// Returns:
+//   - The context error if ctx is done before all tasks complete, or the first deliver error.
+func runTasks(ctx context.Context, tasks []extractionTask, j *journal, deliver func(int, []definitions.Response) error) error {
+	runCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var deliverMutex sync.Mutex
+	complete := func(i int, responses []definitions.Response) {
+		deliverMutex.Lock()
+		defer deliverMutex.Unlock()
+		if runCtx.Err() != nil {
+			return
+		}
+		if err := deliver(i, responses); err != nil {
+			cancel(err)
+		}
+	}
+
+	// Group task indices by pool, keeping pools in order of first appearance
+	poolKeys := []string{}
+	poolTasks := make(map[string][]int)
+	poolSizes := make(map[string]int)
+	for i, task := range tasks {
+		key := model.LimiterKey(task.model)
+		if _, exists := poolTasks[key]; !exists {
+			poolKeys = append(poolKeys, key)
+			poolSizes[key] = 1
+		}
+		poolTasks[key] = append(poolTasks[key], i)
+		if task.model.Concurrency > poolSizes[key] {
+			poolSizes[key] = task.model.Concurrency
+		}
+	}
 
-					outputData.Responses = append(outputData.Responses, outputResponse)
+	var wg sync.WaitGroup
+	for _, key := range poolKeys {
+		queue := make(chan int, len(poolTasks[key]))
+		for _, i := range poolTasks[key] {
+			queue <- i
+		}
+		close(queue)
+
+		for w := 0; w < min(poolSizes[key], len(poolTasks[key])); w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range queue {
+					if runCtx.Err() != nil {
+						return
+					}
+					if responses, done := j.lookup(tasks[i]); done {
+						complete(i, responses)
+						continue
+					}
+					responses := extractSequence(runCtx, tasks[i])
+					if runCtx.Err() != nil {
+						return
+					}
+					if !hasError(responses) {
+						if err := j.record(tasks[i], responses); err != nil {
+							logger.Error(fmt.Sprintf("error recording sequence %s in journal: %v", tasks[i].sequenceID, err))
+						}
+					}
+					complete(i, responses)
 				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	if runCtx.Err() != nil {
+		return context.Cause(runCtx)
+	}
+	return nil
+}
+
+// extractSequence queries the model with the prompts of one sequence and builds its responses.
+// If the model fails the sequence, it is run again from the start on each fallback of the model
+// in turn until one answers every prompt; if all of them fail, the responses of the model itself
+// are kept. When the model has fallbacks, the responses record which model produced them.
+// Failures are reported in the responses; if ctx is done the result is discarded by the caller.
+//
+// Parameters:
+//   - ctx: The context controlling cancellation of the query.
+//   - task: The sequence and the model to query.
+//
+// Returns:
+//   - One response per answered prompt, followed by an error entry if the sequence failed.
+func extractSequence(ctx context.Context, task extractionTask) []definitions.Response {
+	responses := querySequence(ctx, task, task.model)
+	answeredBy := task.model
+	for _, fallback := range task.model.Fallbacks {
+		if !hasError(responses) || ctx.Err() != nil {
+			break
+		}
+		logger.Info(fmt.Sprintf("sequence %s failed on %s, falling back to %s", task.sequenceID, model.LimiterKey(answeredBy), model.LimiterKey(fallback)))
+		if fallbackResponses := querySequence(ctx, task, fallback); !hasError(fallbackResponses) {
+			responses, answeredBy = fallbackResponses, fallback
+		}
+	}
+
+	if len(task.model.Fallbacks) > 0 {
+		for i := range responses {
+			if responses[i].Metadata == nil {
+				responses[i].Metadata = &definitions.ResponseMetadata{}
 			}
+			responses[i].Metadata.AnsweredBy = &definitions.ModelInfo{Provider: answeredBy.Provider, Model: answeredBy.Model}
 		}
 	}
+	return responses
+}
 
-	outputJSON, err := json.Marshal(outputData)
+// querySequence queries one model with the prompts of a sequence. The responses are attributed
+// to the model of the task, so that answers from a fallback keep their place in the output.
+//
+// Parameters:
+//   - ctx: The context controlling cancellation of the query.
+//   - task: The sequence to run.
+//   - llm: The model to query, either the model of the task or one of its fallbacks.
+//
+// Returns:
+//   - One response per answered prompt, followed by an error entry if the sequence failed.
+func querySequence(ctx context.Context, task extractionTask, llm definitions.Model) []definitions.Response {
+	outputResponses := []definitions.Response{}
+
+	// Take a turn at the rate limiter for the first request of the sequence; providers
+	// wait before each following prompt themselves
+	if err := model.Wait(ctx, task.prompts[0].PromptContent, llm); err != nil {
+		return outputResponses
+	}
+
+	// Query the model with all prompts in the sequence at once, already in sequence order
+	answers, err := queryService.QueryLLM(ctx, task.prompts, llm)
 	if err != nil {
-		logger.Error(fmt.Sprintf("error generating output JSON: %v", err))
-		return "", fmt.Errorf("error generating output JSON: %v", err)
+		if ctx.Err() != nil {
+			return outputResponses
+		}
+		logger.Error(fmt.Sprintf("error querying LLM: %v", err))
 	}
 
-	if err := validation.ValidateOutput(string(outputJSON), inputData.Metadata.SchemaVersion); err != nil {
-		logger.Error(fmt.Sprintf("error validating output JSON: %v", err))
-		return "", err
+	// Process answers (they should be in the same order as prompts)
+	for i, p := range task.prompts {
+		outputResponse := definitions.Response{
+			Provider:       task.model.Provider,
+			Model:          task.model.Model,
+			SequenceID:     task.sequenceID,
+			SequenceNumber: p.SequenceNumber,
+			RecordID:       p.RecordID,
+		}
+
+		if i >= len(answers) {
+			// Report the first unanswered prompt so a failure is not mistaken for an empty answer
+			if err != nil {
+				outputResponse.ModelResponses = []string{}
+				outputResponse.Error = model.DescribeError(err)
+				var retryErr *model.RetryError
+				if errors.As(err, &retryErr) {
+					outputResponse.Metadata = &definitions.ResponseMetadata{Attempts: retryErr.Attempts}
+				}
+				outputResponses = append(outputResponses, outputResponse)
+			}
+			break
+		}
+
+		outputResponse.ModelResponses = answers[i].Responses
+		outputResponse.Skipped = answers[i].Skipped
+		if len(answers[i].Attempts) > 0 || answers[i].Repaired || answers[i].Reasks > 0 {
+			outputResponse.Metadata = &definitions.ResponseMetadata{
+				Attempts:     answers[i].Attempts,
+				JSONRepaired: answers[i].Repaired,
+				Reasks:       answers[i].Reasks,
+			}
+		}
+		outputResponses = append(outputResponses, outputResponse)
 	}
+	return outputResponses
+}
 
-	return string(outputJSON), nil
+// hasError reports whether any of the responses carries an error.
+func hasError(responses []definitions.Response) bool {
+	for _, response := range responses {
+		if response.Error != nil {
+			return true
+		}
+	}
+	return false
 }
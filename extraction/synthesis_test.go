@@ -1,8 +1,19 @@
 package extraction
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+	"github.com/open-and-sustainable/alembica/llm/model"
 )
 
 func TestBasicErrorReporting(t *testing.T) {
@@ -33,3 +44,650 @@ func TestBasicErrorReporting(t *testing.T) {
 		})
 	}
 }
+
+// blockingQueryService blocks until the context is done and reports the context error.
+type blockingQueryService struct {
+	started chan struct{}
+}
+
+func (bqs blockingQueryService) QueryLLM(ctx context.Context, prompts []definitions.Prompt, llm definitions.Model) ([]model.Answer, error) {
+	close(bqs.started)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestExtractContextCancellation(t *testing.T) {
+	started := make(chan struct{})
+	original := queryService
+	queryService = blockingQueryService{started: started}
+	defer func() { queryService = original }()
+
+	inputJSON := `{
+		"metadata": {"schemaVersion": "v1", "timestamp": "2025-02-10T12:00:00Z"},
+		"models": [{"provider": "OpenAI", "model": "gpt-4o", "temperature": 0.7}],
+		"prompts": [{"promptContent": "Hello", "sequenceId": "1", "sequenceNumber": 1}]
+	}`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ExtractContext(ctx, inputJSON)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ExtractContext did not return after cancellation")
+	}
+}
+
+// mockQueryService returns canned answers and an optional error for every sequence.
+type mockQueryService struct {
+	answers []model.Answer
+	err     error
+}
+
+func (mqs mockQueryService) QueryLLM(ctx context.Context, prompts []definitions.Prompt, llm definitions.Model) ([]model.Answer, error) {
+	return mqs.answers, mqs.err
+}
+
+func TestExtractReportsFailedSequences(t *testing.T) {
+	original := queryService
+	queryService = mockQueryService{err: fmt.Errorf("%w (OpenAI)", model.ErrContentFiltered)}
+	defer func() { queryService = original }()
+
+	inputJSON := `{
+		"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+		"models": [{"provider": "OpenAI", "model": "gpt-4o", "temperature": 0.7}],
+		"prompts": [
+			{"promptContent": "First", "sequenceId": "1", "sequenceNumber": 1},
+			{"promptContent": "Second", "sequenceId": "1", "sequenceNumber": 2}
+		]
+	}`
+
+	outputJSON, err := Extract(inputJSON)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var output definitions.Output
+	if err := json.Unmarshal([]byte(outputJSON), &output); err != nil {
+		t.Fatalf("invalid output JSON: %v", err)
+	}
+	if len(output.Responses) != 1 {
+		t.Fatalf("expected one error entry, got %d responses", len(output.Responses))
+	}
+
+	response := output.Responses[0]
+	if response.SequenceID != "1" || response.SequenceNumber != 1 {
+		t.Errorf("error reported on the wrong prompt: %+v", response)
+	}
+	if response.Error == nil || response.Error.Category != string(model.ErrorContentFilter) {
+		t.Errorf("expected content-filter error, got %+v", response.Error)
+	}
+	if len(response.ModelResponses) != 0 {
+		t.Errorf("expected no model responses, got %v", response.ModelResponses)
+	}
+}
+
+func TestExtractKeepsPartialAnswers(t *testing.T) {
+	original := queryService
+	queryService = mockQueryService{
+		answers: []model.Answer{{Responses: []string{`{"answer": "first"}`}}},
+		err:     fmt.Errorf("%w from Anthropic: unexpected end of input", model.ErrInvalidJSON),
+	}
+	defer func() { queryService = original }()
+
+	inputJSON := `{
+		"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+		"models": [{"provider": "Anthropic", "model": "claude-3-5-haiku-latest", "temperature": 0.7}],
+		"prompts": [
+			{"promptContent": "First", "sequenceId": "1", "sequenceNumber": 1},
+			{"promptContent": "Second", "sequenceId": "1", "sequenceNumber": 2},
+			{"promptContent": "Third", "sequenceId": "1", "sequenceNumber": 3}
+		]
+	}`
+
+	outputJSON, err := Extract(inputJSON)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var output definitions.Output
+	if err := json.Unmarshal([]byte(outputJSON), &output); err != nil {
+		t.Fatalf("invalid output JSON: %v", err)
+	}
+	if len(output.Responses) != 2 {
+		t.Fatalf("expected one answer and one error entry, got %d responses", len(output.Responses))
+	}
+
+	answered := output.Responses[0]
+	if answered.SequenceNumber != 1 || answered.Error != nil {
+		t.Errorf("expected the first prompt to be answered, got %+v", answered)
+	}
+	if len(answered.ModelResponses) != 1 || answered.ModelResponses[0] != `{"answer": "first"}` {
+		t.Errorf("unexpected answer for the first prompt: %v", answered.ModelResponses)
+	}
+
+	failed := output.Responses[1]
+	if failed.SequenceNumber != 2 {
+		t.Errorf("expected the error on the second prompt, got sequence number %d", failed.SequenceNumber)
+	}
+	if failed.Error == nil || failed.Error.Category != string(model.ErrorInvalidJSON) {
+		t.Errorf("expected invalid-json error, got %+v", failed.Error)
+	}
+}
+
+// concurrentQueryService answers with the sequence's first prompt after a delay that
+// decreases with each call, and records the highest number of calls in flight per model.
+type concurrentQueryService struct {
+	mu       sync.Mutex
+	calls    int
+	inFlight map[string]int
+	peak     map[string]int
+}
+
+func (cqs *concurrentQueryService) QueryLLM(ctx context.Context, prompts []definitions.Prompt, llm definitions.Model) ([]model.Answer, error) {
+	cqs.mu.Lock()
+	cqs.calls++
+	delay := time.Duration(20-cqs.calls%20) * time.Millisecond
+	cqs.inFlight[llm.Model]++
+	cqs.peak[llm.Model] = max(cqs.peak[llm.Model], cqs.inFlight[llm.Model])
+	cqs.mu.Unlock()
+
+	time.Sleep(delay)
+
+	cqs.mu.Lock()
+	cqs.inFlight[llm.Model]--
+	cqs.mu.Unlock()
+	return []model.Answer{{Responses: []string{fmt.Sprintf(`{"prompt": %q}`, prompts[0].PromptContent)}}}, nil
+}
+
+func TestExtractConcurrentDeterministicOrder(t *testing.T) {
+	service := &concurrentQueryService{inFlight: map[string]int{}, peak: map[string]int{}}
+	original := queryService
+	queryService = service
+	defer func() { queryService = original }()
+
+	prompts := []string{}
+	for i := 1; i <= 12; i++ {
+		prompts = append(prompts, fmt.Sprintf(`{"promptContent": "p%d", "sequenceId": "s%d", "sequenceNumber": 1}`, i, i))
+	}
+	inputJSON := fmt.Sprintf(`{
+		"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+		"models": [
+			{"provider": "OpenAI", "model": "gpt-4o", "temperature": 0.7, "concurrency": 4},
+			{"provider": "OpenAI", "model": "gpt-4o-mini", "temperature": 0.7}
+		],
+		"prompts": [%s]
+	}`, strings.Join(prompts, ","))
+
+	outputJSON, err := Extract(inputJSON)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var output definitions.Output
+	if err := json.Unmarshal([]byte(outputJSON), &output); err != nil {
+		t.Fatalf("invalid output JSON: %v", err)
+	}
+	if len(output.Responses) != 24 {
+		t.Fatalf("expected 24 responses, got %d", len(output.Responses))
+	}
+	for i, response := range output.Responses {
+		expectedModel := "gpt-4o"
+		if i >= 12 {
+			expectedModel = "gpt-4o-mini"
+		}
+		expectedSequence := fmt.Sprintf("s%d", i%12+1)
+		if response.Model != expectedModel || response.SequenceID != expectedSequence {
+			t.Errorf("response %d: expected %s/%s, got %s/%s", i, expectedModel, expectedSequence, response.Model, response.SequenceID)
+		}
+	}
+
+	if peak := service.peak["gpt-4o"]; peak < 2 || peak > 4 {
+		t.Errorf("expected between 2 and 4 parallel calls for gpt-4o, got %d", peak)
+	}
+	if peak := service.peak["gpt-4o-mini"]; peak != 1 {
+		t.Errorf("expected sequential calls for gpt-4o-mini, got %d", peak)
+	}
+}
+
+func TestExtractStoresAllSamples(t *testing.T) {
+	withQueryService(t, &countingQueryService{})
+
+	inputJSON := strings.Replace(journalInputJSON, `"concurrency": 2`, `"samples": 3`, 1)
+	outputJSON, err := Extract(inputJSON)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var output definitions.Output
+	if err := json.Unmarshal([]byte(outputJSON), &output); err != nil {
+		t.Fatalf("invalid output JSON: %v", err)
+	}
+	for _, response := range output.Responses {
+		if len(response.ModelResponses) != 3 {
+			t.Errorf("expected 3 samples for %s/%d, got %v", response.SequenceID, response.SequenceNumber, response.ModelResponses)
+		}
+	}
+}
+
+func TestExtractRecordsAttempts(t *testing.T) {
+	original := queryService
+	queryService = mockQueryService{
+		answers: []model.Answer{{
+			Responses: []string{`{"answer": "first"}`},
+			Attempts: []definitions.Attempt{
+				{Attempt: 1, Error: &definitions.ErrorInfo{Code: 503, Category: "provider-5xx", Message: "overloaded"}, DelayMs: 1000},
+				{Attempt: 2},
+			},
+		}},
+		err: &model.RetryError{
+			Attempts: []definitions.Attempt{{Attempt: 1, Error: &definitions.ErrorInfo{Code: 401, Category: "auth", Message: "invalid key"}}},
+			Err:      errors.New("invalid key"),
+		},
+	}
+	defer func() { queryService = original }()
+
+	inputJSON := `{
+		"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+		"models": [{"provider": "OpenAI", "model": "gpt-4o", "temperature": 0.7, "retry": {"max_attempts": 3, "base_delay_ms": 1000, "jitter": 0.2}}],
+		"prompts": [
+			{"promptContent": "First", "sequenceId": "1", "sequenceNumber": 1},
+			{"promptContent": "Second", "sequenceId": "1", "sequenceNumber": 2}
+		]
+	}`
+
+	outputJSON, err := Extract(inputJSON)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var output definitions.Output
+	if err := json.Unmarshal([]byte(outputJSON), &output); err != nil {
+		t.Fatalf("invalid output JSON: %v", err)
+	}
+	if len(output.Responses) != 2 {
+		t.Fatalf("expected one answer and one error entry, got %d responses", len(output.Responses))
+	}
+	if metadata := output.Responses[0].Metadata; metadata == nil || len(metadata.Attempts) != 2 || metadata.Attempts[0].DelayMs != 1000 {
+		t.Errorf("expected the attempts of the answered prompt, got %+v", metadata)
+	}
+	if metadata := output.Responses[1].Metadata; metadata == nil || len(metadata.Attempts) != 1 || metadata.Attempts[0].Error.Category != "auth" {
+		t.Errorf("expected the attempts of the failed prompt, got %+v", metadata)
+	}
+}
+
+// modelQueryService answers with the model name, failing the second prompt of every sequence
+// sent to the models listed in fail.
+type modelQueryService struct {
+	fail map[string]bool
+}
+
+func (mqs modelQueryService) QueryLLM(ctx context.Context, prompts []definitions.Prompt, llm definitions.Model) ([]model.Answer, error) {
+	answers := []model.Answer{}
+	for i := range prompts {
+		if i == 1 && mqs.fail[llm.Model] {
+			return answers, fmt.Errorf("%w: outage", model.ErrContentFiltered)
+		}
+		answers = append(answers, model.Answer{Responses: []string{fmt.Sprintf(`{"model": %q}`, llm.Model)}})
+	}
+	return answers, nil
+}
+
+func TestExtractFallbacks(t *testing.T) {
+	tests := []struct {
+		name             string
+		fail             map[string]bool
+		expectAnsweredBy string
+		expectError      bool
+	}{
+		{name: "Primary answers", fail: map[string]bool{}, expectAnsweredBy: "gpt-4.1-mini"},
+		{name: "Second fallback answers", fail: map[string]bool{"gpt-4.1-mini": true, "gemini-2.5-flash": true}, expectAnsweredBy: "llama3"},
+		{name: "All models fail", fail: map[string]bool{"gpt-4.1-mini": true, "gemini-2.5-flash": true, "llama3": true}, expectAnsweredBy: "gpt-4.1-mini", expectError: true},
+	}
+
+	inputJSON := `{
+		"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+		"models": [{
+			"provider": "OpenAI", "model": "gpt-4.1-mini", "temperature": 0.7,
+			"fallbacks": [
+				{"provider": "GoogleAI", "model": "gemini-2.5-flash", "temperature": 0.7},
+				{"provider": "SelfHosted", "model": "llama3", "temperature": 0.7, "base_url": "http://localhost:8000/v1"}
+			]
+		}],
+		"prompts": [
+			{"promptContent": "First", "sequenceId": "1", "sequenceNumber": 1},
+			{"promptContent": "Second", "sequenceId": "1", "sequenceNumber": 2}
+		]
+	}`
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			original := queryService
+			queryService = modelQueryService{fail: tc.fail}
+			defer func() { queryService = original }()
+
+			outputJSON, err := Extract(inputJSON)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var output definitions.Output
+			if err := json.Unmarshal([]byte(outputJSON), &output); err != nil {
+				t.Fatalf("invalid output JSON: %v", err)
+			}
+			if len(output.Responses) != 2 {
+				t.Fatalf("expected 2 responses, got %d", len(output.Responses))
+			}
+
+			for _, response := range output.Responses {
+				// Responses keep the requested model so they stay in place in the output
+				if response.Model != "gpt-4.1-mini" {
+					t.Errorf("expected the requested model, got %s", response.Model)
+				}
+				if response.Metadata == nil || response.Metadata.AnsweredBy == nil || response.Metadata.AnsweredBy.Model != tc.expectAnsweredBy {
+					t.Errorf("expected the answer to be attributed to %s, got %+v", tc.expectAnsweredBy, response.Metadata)
+				}
+			}
+			if hasError(output.Responses) != tc.expectError {
+				t.Errorf("unexpected error state: %+v", output.Responses)
+			}
+			if !tc.expectError && output.Responses[1].ModelResponses[0] != fmt.Sprintf(`{"model": %q}`, tc.expectAnsweredBy) {
+				t.Errorf("expected the answer of %s, got %v", tc.expectAnsweredBy, output.Responses[1].ModelResponses)
+			}
+		})
+	}
+}
+
+func TestPlanTasksSystemPrompt(t *testing.T) {
+	tests := []struct {
+		name           string
+		inputPrompt    string
+		modelPrompt    string
+		sequencePrompt string
+		expectPrimary  string
+		expectFallback string
+	}{
+		{name: "No system prompt"},
+		{name: "Input default", inputPrompt: "input", expectPrimary: "input", expectFallback: "input"},
+		{name: "Model overrides input", inputPrompt: "input", modelPrompt: "model", expectPrimary: "model", expectFallback: "input"},
+		{name: "Sequence overrides model", inputPrompt: "input", modelPrompt: "model", sequencePrompt: "sequence", expectPrimary: "sequence", expectFallback: "sequence"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			models := []definitions.Model{{
+				Provider: "OpenAI", Model: "gpt-4.1-mini", SystemPrompt: tc.modelPrompt,
+				Fallbacks: []definitions.Model{{Provider: "GoogleAI", Model: "gemini-2.5-flash"}},
+			}}
+			prompts := []definitions.Prompt{
+				{PromptContent: "Second", SequenceID: "1", SequenceNumber: 2, SystemPrompt: tc.sequencePrompt},
+				{PromptContent: "First", SequenceID: "1", SequenceNumber: 1},
+			}
+
+			tasks := planTasks(models, prompts, tc.inputPrompt, nil)
+			if len(tasks) != 1 {
+				t.Fatalf("expected 1 task, got %d", len(tasks))
+			}
+			if tasks[0].model.SystemPrompt != tc.expectPrimary {
+				t.Errorf("expected system prompt %q, got %q", tc.expectPrimary, tasks[0].model.SystemPrompt)
+			}
+			if tasks[0].model.Fallbacks[0].SystemPrompt != tc.expectFallback {
+				t.Errorf("expected fallback system prompt %q, got %q", tc.expectFallback, tasks[0].model.Fallbacks[0].SystemPrompt)
+			}
+			// The models of the input are left untouched
+			if models[0].SystemPrompt != tc.modelPrompt || models[0].Fallbacks[0].SystemPrompt != "" {
+				t.Errorf("input models were modified: %+v", models[0])
+			}
+		})
+	}
+}
+
+func TestCheckSystemPrompts(t *testing.T) {
+	tests := []struct {
+		name        string
+		prompts     []definitions.Prompt
+		expectError string
+	}{
+		{name: "No system prompts", prompts: []definitions.Prompt{
+			{SequenceID: "1", SequenceNumber: 1}, {SequenceID: "1", SequenceNumber: 2},
+		}},
+		{name: "Repeated system prompt", prompts: []definitions.Prompt{
+			{SequenceID: "1", SequenceNumber: 1, SystemPrompt: "same"}, {SequenceID: "1", SequenceNumber: 2}, {SequenceID: "1", SequenceNumber: 3, SystemPrompt: "same"},
+		}},
+		{name: "Different sequences", prompts: []definitions.Prompt{
+			{SequenceID: "1", SequenceNumber: 1, SystemPrompt: "one"}, {SequenceID: "2", SequenceNumber: 1, SystemPrompt: "two"},
+		}},
+		{name: "Conflicting system prompts", prompts: []definitions.Prompt{
+			{SequenceID: "1", SequenceNumber: 1, SystemPrompt: "one"}, {SequenceID: "1", SequenceNumber: 2, SystemPrompt: "two"},
+		}, expectError: "sequence 1: prompts 1 and 2 set different system prompts"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkSystemPrompts(tc.prompts)
+			if tc.expectError == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tc.expectError != "" && (err == nil || err.Error() != tc.expectError) {
+				t.Errorf("expected error %q, got %v", tc.expectError, err)
+			}
+		})
+	}
+}
+
+func TestExtractRejectsInvalidConditions(t *testing.T) {
+	service := &countingQueryService{}
+	withQueryService(t, service)
+
+	inputJSON := `{
+		"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+		"models": [{"provider": "OpenAI", "model": "gpt-4o", "temperature": 0, "fallbacks": [{"provider": "OpenAI", "model": "gpt-4o-mini", "temperature": 0}]}],
+		"prompts": [
+			{"promptContent": "a1", "sequenceId": "a", "sequenceNumber": 1},
+			{"promptContent": "b1", "sequenceId": "b", "sequenceNumber": 1},
+			{"promptContent": "b2", "sequenceId": "b", "sequenceNumber": 2, "condition": {"expression": "step3.included"}}
+		]
+	}`
+
+	_, err := Extract(inputJSON)
+	if !errors.Is(err, model.ErrInvalidCondition) || !strings.Contains(err.Error(), "sequence b") {
+		t.Errorf("expected the invalid condition of sequence b to fail the input, got %v", err)
+	}
+	if len(service.queried) != 0 {
+		t.Errorf("expected no provider to be queried, got %v", service.queried)
+	}
+}
+
+func TestPlanTasksExamples(t *testing.T) {
+	models := []definitions.Model{{Provider: "OpenAI", Model: "gpt-4.1-mini"}}
+	inputExamples := []definitions.Example{{Input: "Input example", Output: json.RawMessage(`{"a": 1}`)}}
+	prompts := []definitions.Prompt{
+		{PromptContent: "a2", SequenceID: "a", SequenceNumber: 2},
+		{PromptContent: "a1", SequenceID: "a", SequenceNumber: 1, Examples: []definitions.Example{{Input: "Prompt example", Output: json.RawMessage(`{"a": 2}`)}}},
+		{PromptContent: "b1", SequenceID: "b", SequenceNumber: 1},
+	}
+
+	tasks := planTasks(models, prompts, "", inputExamples)
+	if len(tasks) != 2 {
+		t.Fatalf("expected 2 tasks, got %d", len(tasks))
+	}
+	first := tasks[0].prompts[0].Examples
+	if len(first) != 2 || first[0].Input != "Input example" || first[1].Input != "Prompt example" {
+		t.Errorf("expected the input examples before those of the first prompt, got %+v", first)
+	}
+	if len(tasks[0].prompts[1].Examples) != 0 {
+		t.Errorf("expected no examples on the second prompt, got %+v", tasks[0].prompts[1].Examples)
+	}
+	if second := tasks[1].prompts[0].Examples; len(second) != 1 || second[0].Input != "Input example" {
+		t.Errorf("expected the input examples on the other sequence, got %+v", second)
+	}
+	// The prompts of the input are left untouched
+	if len(prompts[1].Examples) != 1 || len(prompts[2].Examples) != 0 {
+		t.Errorf("input prompts were modified: %+v", prompts)
+	}
+}
+
+func TestExtractRejectsUnsupportedParameters(t *testing.T) {
+	service := &countingQueryService{}
+	withQueryService(t, service)
+
+	tests := []struct {
+		name        string
+		replacement string
+		expectError string
+	}{
+		{name: "Supported parameters", replacement: `"temperature": 0.7, "max_output_tokens": 16000, "top_p": 0.9, "seed": 7`},
+		{name: "Unsupported parameter", replacement: `"temperature": 0.7, "top_k": 40`, expectError: "model 0 (gpt-4o): unsupported generation parameter: OpenAI does not support top_k"},
+		{
+			name:        "Unsupported parameter on a fallback",
+			replacement: `"temperature": 0.7, "fallbacks": [{"provider": "DeepSeek", "model": "deepseek-chat", "temperature": 0.7, "seed": 7}]`,
+			expectError: "model 0 (gpt-4o), fallback 0 (deepseek-chat): unsupported generation parameter: DeepSeek does not support seed",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			service.queried = nil
+			_, err := Extract(strings.Replace(journalInputJSON, `"temperature": 0.7`, tc.replacement, 1))
+			if tc.expectError == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.expectError {
+				t.Errorf("expected %q, got %v", tc.expectError, err)
+			}
+			if len(service.queried) != 0 {
+				t.Errorf("expected no provider to be queried, got %v", service.queried)
+			}
+		})
+	}
+}
+
+func TestExtractExpandsTemplates(t *testing.T) {
+	withQueryService(t, &countingQueryService{})
+
+	inputJSON := `{
+		"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+		"models": [{"provider": "OpenAI", "model": "gpt-4o", "temperature": 0}],
+		"prompts": [{"promptContent": "Hello", "sequenceId": "greeting", "sequenceNumber": 1}],
+		"templates": {
+			"prompts": [
+				{"promptContent": "Summarize: {{abstract}}", "sequenceNumber": 1},
+				{"promptContent": "Authors of {{doi}}?", "sequenceNumber": 2}
+			],
+			"records": [{"doi": "10.1/a", "abstract": "Corals"}, {"doi": "10.1/b", "abstract": "Kelp"}],
+			"idField": "doi"
+		}
+	}`
+
+	outputJSON, err := Extract(inputJSON)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var output definitions.Output
+	if err := json.Unmarshal([]byte(outputJSON), &output); err != nil {
+		t.Fatalf("invalid output JSON: %v", err)
+	}
+
+	expected := []struct{ sequenceID, recordID, answer string }{
+		{"greeting", "", "Hello"},
+		{"10.1/a", "10.1/a", "Summarize: Corals"},
+		{"10.1/a", "10.1/a", "Authors of 10.1/a?"},
+		{"10.1/b", "10.1/b", "Summarize: Kelp"},
+		{"10.1/b", "10.1/b", "Authors of 10.1/b?"},
+	}
+	if len(output.Responses) != len(expected) {
+		t.Fatalf("expected %d responses, got %+v", len(expected), output.Responses)
+	}
+	for i, e := range expected {
+		response := output.Responses[i]
+		if response.SequenceID != e.sequenceID || response.RecordID != e.recordID || response.ModelResponses[0] != fmt.Sprintf(`{"answer": %q, "sample": 1}`, e.answer) {
+			t.Errorf("response %d: expected %+v, got %+v", i, e, response)
+		}
+	}
+}
+
+func TestExtractRecordSources(t *testing.T) {
+	withQueryService(t, &countingQueryService{})
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "papers.csv"), []byte("doi,abstract\n10.1/a,Corals\n"), 0644)
+
+	inputJSON := `{
+		"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+		"models": [{"provider": "OpenAI", "model": "gpt-4o", "temperature": 0}],
+		"prompts": [],
+		"templates": {
+			"prompts": [{"promptContent": "Summarize: {{abstract}}", "sequenceNumber": 1}],
+			"source": {"path": "papers.csv"},
+			"idField": "doi"
+		}
+	}`
+
+	if _, err := Extract(inputJSON); err == nil || !strings.Contains(err.Error(), "is not allowed") {
+		t.Errorf("expected the record source to be rejected by default, got %v", err)
+	}
+
+	outputJSON, err := Extract(inputJSON, WithRecordDir(dir))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var output definitions.Output
+	if err := json.Unmarshal([]byte(outputJSON), &output); err != nil {
+		t.Fatalf("invalid output JSON: %v", err)
+	}
+	if len(output.Responses) != 1 || output.Responses[0].RecordID != "10.1/a" {
+		t.Errorf("expected one response for the record of the file, got %+v", output.Responses)
+	}
+}
+
+func TestExtractMarksSkippedPrompts(t *testing.T) {
+	original := queryService
+	queryService = mockQueryService{
+		answers: []model.Answer{
+			{Responses: []string{`{"included": false}`}},
+			{Responses: []string{}, Skipped: true},
+		},
+	}
+	defer func() { queryService = original }()
+
+	inputJSON := `{
+		"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+		"models": [{"provider": "OpenAI", "model": "gpt-4o", "temperature": 0.7}],
+		"prompts": [
+			{"promptContent": "Screen", "sequenceId": "1", "sequenceNumber": 1},
+			{"promptContent": "Extract", "sequenceId": "1", "sequenceNumber": 2, "condition": {"path": "step1.included", "equals": true, "otherwise": "stop"}}
+		]
+	}`
+
+	outputJSON, err := Extract(inputJSON)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var output definitions.Output
+	if err := json.Unmarshal([]byte(outputJSON), &output); err != nil {
+		t.Fatalf("invalid output JSON: %v", err)
+	}
+	if len(output.Responses) != 2 || output.Responses[0].Skipped {
+		t.Fatalf("expected an answer and a skipped entry, got %+v", output.Responses)
+	}
+	skipped := output.Responses[1]
+	if !skipped.Skipped || skipped.Error != nil || len(skipped.ModelResponses) != 0 || skipped.ModelResponses == nil {
+		t.Errorf("expected the second prompt marked as skipped with no responses, got %+v", skipped)
+	}
+	if !strings.Contains(outputJSON, `"skipped": true`) && !strings.Contains(outputJSON, `"skipped":true`) {
+		t.Errorf("expected the skipped flag in the output, got %s", outputJSON)
+	}
+}
@@ -8,6 +8,7 @@ Supported LLM Providers:
   - Cohere (Command-R, Command-R+, Command-R7B)
   - Anthropic (Claude-3.5-Sonnet, Claude-3-Haiku, Claude-3-Opus)
   - DeepSeek (DeepSeek-Chat)
+  - Mistral (Mistral Large, Mistral Medium, Mistral Small, Magistral, Ministral, Codestral)
 
 Core Components:
   - Model Selection:
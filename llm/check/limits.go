@@ -44,6 +44,14 @@ const (
 	AnthropicMaxTokens = 200000
 	// DeepSeek Models
 	DeepSeekChatMaxTokens = 64000
+	// Mistral Models
+	MistralLargeMaxTokens  = 256000
+	MistralMediumMaxTokens = 128000
+	MistralSmallMaxTokens  = 128000
+	MagistralMaxTokens     = 128000
+	MinistralMaxTokens     = 128000
+	CodestralMaxTokens     = 256000
+	MistralNemoMaxTokens   = 128000
 )
 
 var ModelMaxTokens = map[string]int{
@@ -86,6 +94,15 @@ var ModelMaxTokens = map[string]int{
 	"claude-3-haiku-20240307":           AnthropicMaxTokens,
 	"deepseek-chat":                     DeepSeekChatMaxTokens,
 	"deepseek-reasoner":                 DeepSeekChatMaxTokens,
+	"mistral-large-latest":              MistralLargeMaxTokens,
+	"mistral-medium-latest":             MistralMediumMaxTokens,
+	"mistral-small-latest":              MistralSmallMaxTokens,
+	"magistral-medium-latest":           MagistralMaxTokens,
+	"magistral-small-latest":            MagistralMaxTokens,
+	"ministral-8b-latest":               MinistralMaxTokens,
+	"ministral-3b-latest":               MinistralMaxTokens,
+	"codestral-latest":                  CodestralMaxTokens,
+	"open-mistral-nemo":                 MistralNemoMaxTokens,
 }
 
 // RunInputLimitsCheck verifies if the number of tokens in given prompts exceed the allowed limits for a specified model.
@@ -2,12 +2,36 @@ package check
 
 import (
 	"fmt"
+	"sync"
+
 	"github.com/open-and-sustainable/alembica/llm/tokens"
 	"github.com/open-and-sustainable/alembica/utils/logger"
 
 	"github.com/openai/openai-go/v3/shared"
 )
 
+// Model checks of the providers registered with RegisterModelCheck.
+var (
+	modelChecksMu sync.RWMutex
+	modelChecks   = map[string]func(prompt string, modelName string, key string) string{}
+)
+
+// RegisterModelCheck sets how GetModel selects the models of a provider that is not built into
+// alembica. It is called by model.RegisterProvider.
+//
+// Parameters:
+//   - providerName: The name of the provider.
+//   - check: Returns the model to use for a requested model name, or an empty string if the
+//     model is unsupported; nil accepts any non-empty model name.
+func RegisterModelCheck(providerName string, check func(prompt string, modelName string, key string) string) {
+	modelChecksMu.Lock()
+	defer modelChecksMu.Unlock()
+	if check == nil {
+		check = getPassthroughModel
+	}
+	modelChecks[providerName] = check
+}
+
 // GetModel selects the appropriate model for the given provider based on user input and internal logic.
 //
 // Parameters:
@@ -18,6 +42,7 @@ import (
 //
 // Returns:
 //   - A string representing the selected model name. An empty string is returned if the model is unsupported.
+//     Providers registered with RegisterModelCheck use their own check.
 //
 // Example:
 //
@@ -40,11 +65,19 @@ func GetModel(prompt string, providerName string, modelName string, key string)
 		modelFunc = getDeepSeekModel
 	case "Perplexity":
 		modelFunc = getPerplexityModel
-	case "AWSBedrock", "AzureAI", "VertexAI", "SelfHosted":
+	case "Mistral":
+		modelFunc = getMistralModel
+	case "AWSBedrock", "AzureAI", "VertexAI", "SelfHosted", "Ollama":
 		modelFunc = getPassthroughModel
 	default:
-		logger.Error(fmt.Sprintf("Unsupported LLM provider: %s", providerName))
-		return ""
+		modelChecksMu.RLock()
+		check, registered := modelChecks[providerName]
+		modelChecksMu.RUnlock()
+		if !registered {
+			logger.Error(fmt.Sprintf("Unsupported LLM provider: %s", providerName))
+			return ""
+		}
+		modelFunc = check
 	}
 	return modelFunc(prompt, modelName, key)
 }
@@ -242,3 +275,34 @@ func getPerplexityModel(prompt string, modelName string, key string) string {
 	}
 	return model
 }
+
+func getMistralModel(prompt string, modelName string, key string) string {
+	model := "ministral-3b-latest"
+	switch modelName {
+	case "":
+		// cost optimization: use ministral 3b as the cheapest
+		model = "ministral-3b-latest"
+	case "mistral-large-latest":
+		model = modelName
+	case "mistral-medium-latest":
+		model = modelName
+	case "mistral-small-latest":
+		model = modelName
+	case "magistral-medium-latest":
+		model = modelName
+	case "magistral-small-latest":
+		model = modelName
+	case "ministral-8b-latest":
+		model = modelName
+	case "ministral-3b-latest":
+		model = modelName
+	case "codestral-latest":
+		model = modelName
+	case "open-mistral-nemo":
+		model = modelName
+	default:
+		logger.Error(fmt.Sprintf("Unsupported model: %s", modelName))
+		return ""
+	}
+	return model
+}
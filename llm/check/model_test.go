@@ -21,6 +21,9 @@ func TestGetModelSupportedModels(t *testing.T) {
 		{"Anthropic Claude-3.7 Sonnet", "prompt", "Anthropic", "claude-3-7-sonnet", "api-key", "claude-3-7-sonnet-20250219"},
 		{"Perplexity Sonar", "prompt", "Perplexity", "sonar", "api-key", "sonar"},
 		{"Perplexity Sonar Pro", "prompt", "Perplexity", "sonar-pro", "api-key", "sonar-pro"},
+		{"Mistral Large", "prompt", "Mistral", "mistral-large-latest", "api-key", "mistral-large-latest"},
+		{"Mistral default", "prompt", "Mistral", "", "api-key", "ministral-3b-latest"},
+		{"Mistral unknown model", "prompt", "Mistral", "mistral-tiny-9", "api-key", ""},
 	}
 
 	for _, tt := range tests {
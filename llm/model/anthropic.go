@@ -1,9 +1,9 @@
 package model
 
 import (
+	"cmp"
 	"context"
 	"fmt"
-	"strings"
 
 	"github.com/open-and-sustainable/alembica/definitions"
 	"github.com/open-and-sustainable/alembica/utils/logger"
@@ -12,31 +12,77 @@ import (
 	"github.com/anthropics/anthropic-sdk-go/option"
 )
 
-func queryAnthropic(prompts []string, llm definitions.Model) ([]string, error) {
-	answers := []string{}
-	var messages []anthropic.MessageParam
+// anthropicDefaultMaxTokens is the output token limit used when the model sets none, since the
+// Messages API requires one.
+const anthropicDefaultMaxTokens = 4096
 
-	client := anthropic.NewClient(
+func queryAnthropic(ctx context.Context, prompts []definitions.Prompt, llm definitions.Model) ([]Answer, error) {
+	options := []option.RequestOption{
 		option.WithAPIKey(llm.APIKey),
-	)
-
-	for i, prompt := range prompts {
-		// Append new user message to the conversation history
-		messages = append(messages, anthropic.NewUserMessage(anthropic.NewTextBlock(prompt)))
+	}
+	if llm.Retry != nil {
+		options = append(options, option.WithMaxRetries(0))
+	}
+	client := anthropic.NewClient(options...)
+
+	return runSequence(ctx, prompts, llm, func(ctx context.Context, request completion) ([]string, error) {
+		var messages []anthropic.MessageParam
+		for _, m := range request.messages {
+			if m.role == roleAssistant {
+				messages = append(messages, anthropic.NewAssistantMessage(anthropic.NewTextBlock(m.content)))
+			} else {
+				messages = append(messages, anthropic.NewUserMessage(anthropic.NewTextBlock(m.content)))
+			}
+		}
 
-		// Send the updated conversation history to the model
-		message, err := client.Messages.New(context.TODO(), anthropic.MessageNewParams{
+		params := anthropic.MessageNewParams{
 			Model:       anthropic.Model(llm.Model),
-			MaxTokens:   4096,
+			MaxTokens:   int64(cmp.Or(llm.MaxOutputTokens, anthropicDefaultMaxTokens)),
 			Temperature: anthropic.Float(llm.Temperature),
 			Messages:    messages,
-			System: []anthropic.TextBlockParam{
-				{Text: "Respond with properly formatted JSON."},
-			},
-		})
+		}
+		if request.system != "" {
+			params.System = append(params.System, anthropic.TextBlockParam{Text: request.system})
+		}
+		// Anthropic has no JSON mode, so JSON answers are asked for in the instructions
+		if request.format != formatText {
+			params.System = append(params.System, anthropic.TextBlockParam{Text: "Respond with properly formatted JSON."})
+		}
+		if llm.TopP != nil {
+			params.TopP = anthropic.Float(*llm.TopP)
+		}
+		if llm.TopK > 0 {
+			params.TopK = anthropic.Int(int64(llm.TopK))
+		}
+		params.StopSequences = llm.Stop
+		if len(llm.ExtraParams) > 0 {
+			params.SetExtraFields(llm.ExtraParams)
+		}
+
+		schema, err := responseToolSchema(request)
+		if err != nil {
+			return nil, err
+		}
+		forced := schema != nil
+		if forced {
+			params.Tools = []anthropic.ToolUnionParam{{OfTool: &anthropic.ToolParam{
+				Name:        responseToolName,
+				Description: anthropic.String(responseToolDescription),
+				InputSchema: anthropicToolSchema(schema),
+			}}}
+			params.ToolChoice = anthropic.ToolChoiceUnionParam{OfTool: &anthropic.ToolChoiceToolParam{Name: responseToolName}}
+		}
+
+		// Send the conversation history to the model
+		message, err := client.Messages.New(ctx, params)
 		if err != nil {
 			logger.Error(fmt.Sprintf("Anthropic API error: %v", err))
-			return nil, fmt.Errorf("[Anthropic] API error: %v", err)
+			return nil, fmt.Errorf("[Anthropic] API error: %w", err)
+		}
+
+		if message != nil && message.StopReason == anthropic.StopReasonRefusal {
+			logger.Error("Anthropic refused to answer the prompt")
+			return nil, fmt.Errorf("%w (Anthropic)", ErrContentFiltered)
 		}
 
 		// Check if response content is valid
@@ -45,30 +91,23 @@ func queryAnthropic(prompts []string, llm definitions.Model) ([]string, error) {
 			return nil, fmt.Errorf("nil or empty response from Anthropic API")
 		}
 
-		// Log the response from Anthropic
-		logger.Info(fmt.Sprintf("Anthropic response first block: %s", message.Content[0].Text))
-
-		// Extract response text
-		textBlock := extractTextBlock(message.Content)
-
-		// Append assistant response to history
-		messages = append(messages, anthropic.NewAssistantMessage(anthropic.NewTextBlock(textBlock)))
-
-		// Extract valid JSON from response
-		answer, err := extractJSONString(textBlock)
-		if err != nil {
-			logger.Error(fmt.Sprintf("Failed to extract JSON from response: %v", err))
-			return nil, fmt.Errorf("no valid JSON review response from Anthropic: %v", err)
+		// The structured answer is the input of the forced tool call
+		if forced {
+			input := extractToolInput(message.Content)
+			if input == "" {
+				logger.Error("No tool call found in Anthropic response")
+				return nil, fmt.Errorf("%w from Anthropic: no %s tool call", ErrInvalidJSON, responseToolName)
+			}
+			logger.Info(fmt.Sprintf("Anthropic tool input: %s", input))
+			return []string{input}, nil
 		}
-		answers = append(answers, answer)
 
-		// Call wait for all prompts except the last one
-		if i < len(prompts)-1 {
-			Wait(prompt, llm)
-		}
-	}
+		// Log the response from Anthropic
+		logger.Info(fmt.Sprintf("Anthropic response first block: %s", message.Content[0].Text))
 
-	return answers, nil
+		// The JSON answer is extracted from the text by the shared sequence loop
+		return []string{extractTextBlock(message.Content)}, nil
+	})
 }
 
 // extractTextBlock extracts the first text block from the model's response.
@@ -81,35 +120,35 @@ func extractTextBlock(content []anthropic.ContentBlockUnion) string {
 	return ""
 }
 
-// extractJSONString wraps extractSubstring to return properly formatted JSON.
-func extractJSONString(text string) (string, error) {
-	extracted, err := extractSubstring(text, "{", "}")
-	if err != nil {
-		return "", err
+// extractToolInput returns the input of the response tool call in the model's response.
+func extractToolInput(content []anthropic.ContentBlockUnion) string {
+	for _, block := range content {
+		if block.Type == "tool_use" && block.Name == responseToolName {
+			return string(block.Input)
+		}
 	}
-	return "{\n" + extracted + "\n}", nil
+	return ""
 }
 
-// Function to extract substring between first occurrences of two delimiters
-func extractSubstring(s, startDelim, endDelim string) (string, error) {
-	// Find the index of the first occurrence of the start delimiter
-	startIndex := strings.Index(s, startDelim)
-	if startIndex == -1 {
-		return "", fmt.Errorf("start delimiter not found")
+// anthropicToolSchema converts a response schema to the input schema of a tool.
+func anthropicToolSchema(schema map[string]any) anthropic.ToolInputSchemaParam {
+	input := anthropic.ToolInputSchemaParam{
+		Properties:  schema["properties"],
+		ExtraFields: map[string]any{},
 	}
-
-	// Adjust the start index to skip over the start delimiter
-	startIndex += len(startDelim)
-
-	// Find the index of the first occurrence of the end delimiter after the start delimiter
-	endIndex := strings.Index(s[startIndex:], endDelim)
-	if endIndex == -1 {
-		return "", fmt.Errorf("end delimiter not found")
+	if required, ok := schema["required"].([]any); ok {
+		for _, name := range required {
+			if s, ok := name.(string); ok {
+				input.Required = append(input.Required, s)
+			}
+		}
 	}
-
-	// Adjust the endIndex relative to the original string
-	endIndex += startIndex
-
-	// Extract the substring between the two delimiters
-	return s[startIndex:endIndex], nil
+	for key, value := range schema {
+		switch key {
+		case "type", "properties", "required":
+		default:
+			input.ExtraFields[key] = value
+		}
+	}
+	return input
 }
@@ -2,20 +2,15 @@ package model
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net/url"
 	"strings"
 
 	"github.com/open-and-sustainable/alembica/definitions"
-	"github.com/open-and-sustainable/alembica/utils/logger"
-	"github.com/openai/openai-go/v3"
 	"github.com/openai/openai-go/v3/option"
 )
 
-func queryAzureAI(prompts []string, llm definitions.Model) ([]string, error) {
-	answers := []string{}
-
+func queryAzureAI(ctx context.Context, prompts []definitions.Prompt, llm definitions.Model) ([]Answer, error) {
 	if llm.BaseURL == "" {
 		return nil, fmt.Errorf("missing base_url for AzureAI provider")
 	}
@@ -27,50 +22,11 @@ func queryAzureAI(prompts []string, llm definitions.Model) ([]string, error) {
 	deployment := url.PathEscape(llm.Model)
 	baseURL := fmt.Sprintf("%s/openai/deployments/%s", endpoint, deployment)
 
-	client := openai.NewClient(
+	client := newOpenAIClient(llm,
 		option.WithBaseURL(baseURL),
 		option.WithHeader("Api-Key", llm.APIKey),
 		option.WithQuery("api-version", llm.APIVersion),
 	)
 
-	messages := []openai.ChatCompletionMessageParamUnion{}
-
-	for i, prompt := range prompts {
-		messages = append(messages, openai.UserMessage(prompt))
-
-		resp, err := client.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
-			Model:    openai.ChatModel(llm.Model),
-			Messages: messages,
-			ResponseFormat: openai.ChatCompletionNewParamsResponseFormatUnion{
-				OfJSONObject: &openai.ResponseFormatJSONObjectParam{},
-			},
-			Temperature: openai.Float(llm.Temperature),
-		})
-		if err != nil {
-			logger.Error(fmt.Sprintf("Completion error: %v", err))
-			return nil, fmt.Errorf("no response from AzureAI: %v", err)
-		}
-
-		respJSON, err := json.MarshalIndent(resp, "", "  ")
-		if err != nil {
-			logger.Error(fmt.Sprintf("Failed to marshal response: %v", err))
-			return nil, err
-		}
-		logger.Info(fmt.Sprintf("Full AzureAI response: %s", string(respJSON)))
-
-		if len(resp.Choices) == 0 || resp.Choices[0].Message.Content == "" {
-			logger.Error("No content found in response")
-			return nil, fmt.Errorf("no content in response")
-		}
-
-		answer := resp.Choices[0].Message.Content
-		answers = append(answers, answer)
-		messages = append(messages, openai.AssistantMessage(answer))
-
-		if i < len(prompts)-1 {
-			Wait(prompt, llm)
-		}
-	}
-
-	return answers, nil
+	return runSequence(ctx, prompts, llm, openAICompleter(client, llm, openAIEndpoint{provider: "AzureAI", nativeSamples: true}))
 }
@@ -10,44 +10,88 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/document"
 	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
 )
 
-func queryAWSBedrock(prompts []string, llm definitions.Model) ([]string, error) {
-	answers := []string{}
-
+func queryAWSBedrock(ctx context.Context, prompts []definitions.Prompt, llm definitions.Model) ([]Answer, error) {
 	if llm.Region == "" {
 		return nil, fmt.Errorf("missing region for AWSBedrock provider")
 	}
 
-	ctx := context.Background()
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(llm.Region))
+	loadOptions := []func(*config.LoadOptions) error{config.WithRegion(llm.Region)}
+	if llm.Retry != nil {
+		loadOptions = append(loadOptions, config.WithRetryMaxAttempts(1))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, loadOptions...)
 	if err != nil {
 		logger.Error(fmt.Sprintf("AWS config error: %v", err))
 		return nil, err
 	}
 
 	client := bedrockruntime.NewFromConfig(cfg)
-	messages := []types.Message{}
 
-	for i, prompt := range prompts {
-		messages = append(messages, types.Message{
-			Role: types.ConversationRoleUser,
-			Content: []types.ContentBlock{
-				&types.ContentBlockMemberText{Value: prompt},
-			},
-		})
+	return runSequence(ctx, prompts, llm, func(ctx context.Context, request completion) ([]string, error) {
+		messages := []types.Message{}
+		for _, m := range request.messages {
+			role := types.ConversationRoleUser
+			if m.role == roleAssistant {
+				role = types.ConversationRoleAssistant
+			}
+			messages = append(messages, types.Message{
+				Role: role,
+				Content: []types.ContentBlock{
+					&types.ContentBlockMemberText{Value: m.content},
+				},
+			})
+		}
 
-		resp, err := client.Converse(ctx, &bedrockruntime.ConverseInput{
+		input := &bedrockruntime.ConverseInput{
 			ModelId:  aws.String(llm.Model),
 			Messages: messages,
 			InferenceConfig: &types.InferenceConfiguration{
-				Temperature: aws.Float32(float32(llm.Temperature)),
+				Temperature:   aws.Float32(float32(llm.Temperature)),
+				StopSequences: llm.Stop,
 			},
-		})
+		}
+		if llm.MaxOutputTokens > 0 {
+			input.InferenceConfig.MaxTokens = aws.Int32(int32(llm.MaxOutputTokens))
+		}
+		if llm.TopP != nil {
+			input.InferenceConfig.TopP = aws.Float32(float32(*llm.TopP))
+		}
+		// Parameters specific to the model family, such as top_k, go in the additional fields
+		if len(llm.ExtraParams) > 0 {
+			input.AdditionalModelRequestFields = document.NewLazyDocument(llm.ExtraParams)
+		}
+		if request.system != "" {
+			input.System = []types.SystemContentBlock{&types.SystemContentBlockMemberText{Value: request.system}}
+		}
+
+		schema, err := responseToolSchema(request)
+		if err != nil {
+			return nil, err
+		}
+		forced := schema != nil
+		if forced {
+			input.ToolConfig = &types.ToolConfiguration{
+				Tools: []types.Tool{&types.ToolMemberToolSpec{Value: types.ToolSpecification{
+					Name:        aws.String(responseToolName),
+					Description: aws.String(responseToolDescription),
+					InputSchema: &types.ToolInputSchemaMemberJson{Value: document.NewLazyDocument(schema)},
+				}}},
+				ToolChoice: &types.ToolChoiceMemberTool{Value: types.SpecificToolChoice{Name: aws.String(responseToolName)}},
+			}
+		}
+
+		resp, err := client.Converse(ctx, input)
 		if err != nil {
 			logger.Error(fmt.Sprintf("Bedrock API error: %v", err))
-			return nil, fmt.Errorf("no response from AWS Bedrock: %v", err)
+			return nil, fmt.Errorf("no response from AWS Bedrock: %w", err)
+		}
+
+		if resp.StopReason == types.StopReasonContentFiltered || resp.StopReason == types.StopReasonGuardrailIntervened {
+			return nil, fmt.Errorf("%w (AWS Bedrock)", ErrContentFiltered)
 		}
 
 		outputMessage, ok := resp.Output.(*types.ConverseOutputMemberMessage)
@@ -55,25 +99,21 @@ func queryAWSBedrock(prompts []string, llm definitions.Model) ([]string, error)
 			return nil, fmt.Errorf("empty response from AWS Bedrock")
 		}
 
+		// The structured answer is the input of the forced tool call
+		if forced {
+			answer, err := extractBedrockToolInput(outputMessage.Value.Content)
+			if err != nil {
+				return nil, fmt.Errorf("%w from AWS Bedrock: %v", ErrInvalidJSON, err)
+			}
+			return []string{answer}, nil
+		}
+
 		answer := extractBedrockText(outputMessage.Value.Content)
 		if answer == "" {
 			return nil, fmt.Errorf("no content in response")
 		}
-
-		answers = append(answers, answer)
-		messages = append(messages, types.Message{
-			Role: types.ConversationRoleAssistant,
-			Content: []types.ContentBlock{
-				&types.ContentBlockMemberText{Value: answer},
-			},
-		})
-
-		if i < len(prompts)-1 {
-			Wait(prompt, llm)
-		}
-	}
-
-	return answers, nil
+		return []string{answer}, nil
+	})
 }
 
 func extractBedrockText(blocks []types.ContentBlock) string {
@@ -85,3 +125,20 @@ func extractBedrockText(blocks []types.ContentBlock) string {
 	}
 	return ""
 }
+
+// extractBedrockToolInput returns the input of the response tool call as JSON.
+func extractBedrockToolInput(blocks []types.ContentBlock) (string, error) {
+	for _, block := range blocks {
+		if toolUse, ok := block.(*types.ContentBlockMemberToolUse); ok && aws.ToString(toolUse.Value.Name) == responseToolName {
+			if toolUse.Value.Input == nil {
+				return "", fmt.Errorf("empty %s tool input", responseToolName)
+			}
+			input, err := toolUse.Value.Input.MarshalSmithyDocument()
+			if err != nil {
+				return "", err
+			}
+			return string(input), nil
+		}
+	}
+	return "", fmt.Errorf("no %s tool call", responseToolName)
+}
@@ -4,38 +4,63 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+
 	"github.com/open-and-sustainable/alembica/definitions"
 	"github.com/open-and-sustainable/alembica/utils/logger"
 
 	cohere "github.com/cohere-ai/cohere-go/v2"
 	cohereclient "github.com/cohere-ai/cohere-go/v2/client"
-	uuid "github.com/google/uuid"
+	cohereoption "github.com/cohere-ai/cohere-go/v2/option"
 )
 
-func queryCohere(prompts []string, llm definitions.Model) ([]string, error) {
-	answers := []string{}
-	chatID := uuid.New().String()
-
+func queryCohere(ctx context.Context, prompts []definitions.Prompt, llm definitions.Model) ([]Answer, error) {
 	// Create a new Cohere client
-	client := cohereclient.NewClient(cohereclient.WithToken(llm.APIKey))
-
-	for i, prompt := range prompts {
-		chatRequest := &cohere.ChatRequest{
-			Message:        prompt,
-			Model:          &llm.Model,
-			ConversationId: &chatID, // Maintain conversation ID
-			Temperature:    &llm.Temperature,
+	options := []cohereoption.RequestOption{cohereclient.WithToken(llm.APIKey)}
+	if llm.BaseURL != "" {
+		options = append(options, cohereoption.WithBaseURL(llm.BaseURL))
+	}
+	if llm.Retry != nil {
+		options = append(options, cohereclient.WithMaxAttempts(1))
+	}
+	client := cohereclient.NewClient(options...)
+
+	// The v2 chat API keeps no state: the whole conversation is sent with every request,
+	// so repeated calls for additional samples do not pollute the conversation
+	return runSequence(ctx, prompts, llm, func(ctx context.Context, request completion) ([]string, error) {
+		chatRequest := &cohere.V2ChatRequest{
+			Model:            llm.Model,
+			Messages:         cohereMessages(request.system, request.messages),
+			Temperature:      &llm.Temperature,
+			P:                llm.TopP,
+			StopSequences:    llm.Stop,
+			PresencePenalty:  llm.PresencePenalty,
+			FrequencyPenalty: llm.FrequencyPenalty,
+		}
+		if llm.MaxOutputTokens > 0 {
+			chatRequest.MaxTokens = &llm.MaxOutputTokens
+		}
+		if llm.TopK > 0 {
+			chatRequest.K = &llm.TopK
+		}
+		if llm.Seed != nil {
+			seed := int(*llm.Seed)
+			chatRequest.Seed = &seed
 		}
+		responseFormat, err := cohereResponseFormat(request)
+		if err != nil {
+			return nil, err
+		}
+		chatRequest.ResponseFormat = responseFormat
 
 		// Log request for debugging
 		reqJSON, _ := json.MarshalIndent(chatRequest, "", "  ")
 		logger.Info(fmt.Sprintf("Sending Cohere request: %s", string(reqJSON)))
 
 		// Make API call
-		response, err := client.Chat(context.TODO(), chatRequest)
+		response, err := client.V2.Chat(ctx, chatRequest, cohereoption.WithBodyProperties(llm.ExtraParams))
 		if err != nil {
 			logger.Error(fmt.Sprintf("Cohere API error: %v", err))
-			return nil, fmt.Errorf("[Cohere] API error: %v", err)
+			return nil, fmt.Errorf("[Cohere] API error: %w", err)
 		}
 
 		// Check if response is nil before accessing its fields
@@ -51,21 +76,96 @@ func queryCohere(prompts []string, llm definitions.Model) ([]string, error) {
 			return nil, err
 		}
 		logger.Info(fmt.Sprintf("Full Cohere response: %s", string(respJSON)))
+		logCohereUsage(response.Usage)
+
+		// Failed and timed out generations may come with partial text, which is not an answer
+		switch response.FinishReason {
+		case cohere.ChatFinishReasonError:
+			logger.Error("Cohere generation failed")
+			return nil, fmt.Errorf("%w (Cohere)", ErrGenerationFailed)
+		case cohere.ChatFinishReasonTimeout:
+			logger.Error("Cohere generation timed out")
+			return nil, fmt.Errorf("%w (Cohere)", ErrGenerationTimeout)
+		}
 
 		// Ensure valid response
-		if len(response.Text) == 0 {
+		text := cohereText(response.Message)
+		if text == "" {
 			logger.Error("No content found in response")
-			return nil, fmt.Errorf("no content in response from Cohere")
+			return nil, fmt.Errorf("no content in response from Cohere (finish reason %s)", response.FinishReason)
+		}
+
+		return []string{text}, nil
+	})
+}
+
+// cohereMessages converts the conversation to v2 chat messages, preceded by the system prompt
+// if there is one.
+func cohereMessages(system string, messages []message) cohere.ChatMessages {
+	chatMessages := make(cohere.ChatMessages, 0, len(messages)+1)
+	if system != "" {
+		chatMessages = append(chatMessages, &cohere.ChatMessageV2{
+			Role:   "system",
+			System: &cohere.SystemMessageV2{Content: &cohere.SystemMessageV2Content{String: system}},
+		})
+	}
+	for _, m := range messages {
+		if m.role == roleAssistant {
+			chatMessages = append(chatMessages, &cohere.ChatMessageV2{
+				Role:      "assistant",
+				Assistant: &cohere.AssistantMessage{Content: &cohere.AssistantMessageV2Content{String: m.content}},
+			})
+		} else {
+			chatMessages = append(chatMessages, &cohere.ChatMessageV2{
+				Role: "user",
+				User: &cohere.UserMessageV2{Content: &cohere.UserMessageV2Content{String: m.content}},
+			})
 		}
+	}
+	return chatMessages
+}
 
-		// Append response to answers slice
-		answers = append(answers, response.Text)
+// cohereResponseFormat returns the JSON object response format for JSON answers, with the
+// response schema of the prompt if it has one, and nil for text answers.
+func cohereResponseFormat(request completion) (*cohere.ResponseFormatV2, error) {
+	switch {
+	case request.responseSchema != nil:
+		schema, err := schemaObject(request.responseSchema)
+		if err != nil {
+			return nil, err
+		}
+		return &cohere.ResponseFormatV2{Type: "json_object", JsonObject: &cohere.JsonResponseFormatV2{JsonSchema: schema}}, nil
+	case request.format == formatJSON:
+		return &cohere.ResponseFormatV2{Type: "json_object", JsonObject: &cohere.JsonResponseFormatV2{}}, nil
+	}
+	return nil, nil
+}
 
-		// Call wait for all prompts except the last one
-		if i < len(prompts)-1 {
-			Wait(prompt, llm)
+// cohereText joins the text parts of an answer, leaving out any thinking.
+func cohereText(answer *cohere.AssistantMessageResponse) string {
+	if answer == nil {
+		return ""
+	}
+	text := ""
+	for _, item := range answer.Content {
+		if item != nil && item.Text != nil {
+			text += item.Text.Text
 		}
 	}
+	return text
+}
 
-	return answers, nil
+// logCohereUsage logs the tokens billed for a request.
+func logCohereUsage(usage *cohere.Usage) {
+	if usage == nil || usage.BilledUnits == nil {
+		return
+	}
+	var input, output float64
+	if usage.BilledUnits.InputTokens != nil {
+		input = *usage.BilledUnits.InputTokens
+	}
+	if usage.BilledUnits.OutputTokens != nil {
+		output = *usage.BilledUnits.OutputTokens
+	}
+	logger.Info(fmt.Sprintf("Cohere billed %.0f input and %.0f output tokens", input, output))
 }
@@ -0,0 +1,161 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+)
+
+// newCohereServer starts a stand-in for the Cohere v2 chat API that records the body of each
+// request and answers with the given content.
+func newCohereServer(t *testing.T, content string, bodies *[]map[string]json.RawMessage) *httptest.Server {
+	t.Helper()
+	return newCohereServerFinishing(t, content, "COMPLETE", bodies)
+}
+
+// newCohereServerFinishing is newCohereServer with the given finish reason.
+func newCohereServerFinishing(t *testing.T, content, finishReason string, bodies *[]map[string]json.RawMessage) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/chat" {
+			http.NotFound(w, r)
+			return
+		}
+		var body map[string]json.RawMessage
+		json.NewDecoder(r.Body).Decode(&body)
+		*bodies = append(*bodies, body)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"id":            "chat-test",
+			"finish_reason": finishReason,
+			"message": map[string]any{
+				"role":    "assistant",
+				"content": []map[string]any{{"type": "text", "text": content}},
+			},
+			"usage": map[string]any{"billed_units": map[string]any{"input_tokens": 12, "output_tokens": 5}},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestQueryCohereResponseFormat(t *testing.T) {
+	schema := json.RawMessage(`{"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}`)
+
+	tests := []struct {
+		name         string
+		prompt       definitions.Prompt
+		answer       string
+		expectFormat string
+	}{
+		{name: "JSON mode by default", answer: `{"city": "Paris"}`, expectFormat: `{"type":"json_object"}`},
+		{name: "Schema", prompt: definitions.Prompt{ResponseSchema: schema}, answer: `{"city": "Paris"}`,
+			expectFormat: `{"type":"json_object","json_schema":{"properties":{"city":{"type":"string"}},"required":["city"],"type":"object"}}`},
+		{name: "Text sends no response format", prompt: definitions.Prompt{ResponseFormat: formatText}, answer: "Paris.", expectFormat: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var bodies []map[string]json.RawMessage
+			server := newCohereServer(t, tc.answer, &bodies)
+			llm := definitions.Model{Provider: "Cohere", Model: "command-a-03-2025", APIKey: "key", BaseURL: server.URL}
+
+			prompt := tc.prompt
+			prompt.PromptContent, prompt.SequenceID, prompt.SequenceNumber = "Capital of France?", "1", 1
+			answers, err := queryCohere(context.Background(), []definitions.Prompt{prompt}, llm)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if answers[0].Responses[0] != tc.answer {
+				t.Errorf("expected %q, got %q", tc.answer, answers[0].Responses[0])
+			}
+
+			format := bodies[0]["response_format"]
+			if tc.expectFormat == "" && format != nil {
+				t.Errorf("unexpected response_format %s", format)
+			}
+			if tc.expectFormat != "" && !equalJSON(format, json.RawMessage(tc.expectFormat)) {
+				t.Errorf("expected response_format %s, got %s", tc.expectFormat, format)
+			}
+		})
+	}
+}
+
+func TestQueryCohereHistory(t *testing.T) {
+	type chatMessage struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	}
+	var bodies []map[string]json.RawMessage
+	server := newCohereServer(t, `{"ok": true}`, &bodies)
+	llm := definitions.Model{Provider: "Cohere", Model: "command-a-03-2025", APIKey: "key", BaseURL: server.URL, SystemPrompt: "You extract data."}
+
+	if _, err := queryCohere(context.Background(), promptsOf("First", "Second"), llm); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(bodies) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(bodies))
+	}
+	for _, body := range bodies {
+		if _, exists := body["conversation_id"]; exists {
+			t.Errorf("unexpected server-side conversation: %s", body["conversation_id"])
+		}
+	}
+
+	// The second request replays the whole conversation after the system prompt
+	var messages []chatMessage
+	if err := json.Unmarshal(bodies[1]["messages"], &messages); err != nil {
+		t.Fatalf("unexpected messages %s: %v", bodies[1]["messages"], err)
+	}
+	expected := []chatMessage{
+		{Role: "system", Content: "You extract data."},
+		{Role: "user", Content: "First"},
+		{Role: "assistant", Content: `{"ok": true}`},
+		{Role: "user", Content: "Second"},
+	}
+	if len(messages) != len(expected) {
+		t.Fatalf("expected %+v, got %+v", expected, messages)
+	}
+	for i := range expected {
+		if messages[i] != expected[i] {
+			t.Errorf("message %d: expected %+v, got %+v", i, expected[i], messages[i])
+		}
+	}
+}
+
+func TestQueryCohereFinishReasons(t *testing.T) {
+	tests := []struct {
+		finishReason   string
+		expectError    error
+		expectCategory ErrorCategory
+	}{
+		{finishReason: "COMPLETE"},
+		{finishReason: "MAX_TOKENS"},
+		{finishReason: "ERROR", expectError: ErrGenerationFailed, expectCategory: ErrorProvider},
+		{finishReason: "TIMEOUT", expectError: ErrGenerationTimeout, expectCategory: ErrorTimeout},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.finishReason, func(t *testing.T) {
+			var bodies []map[string]json.RawMessage
+			server := newCohereServerFinishing(t, `{"ok": true}`, tc.finishReason, &bodies)
+			llm := definitions.Model{Provider: "Cohere", Model: "command-a-03-2025", APIKey: "key", BaseURL: server.URL}
+
+			_, err := queryCohere(context.Background(), promptsOf("First"), llm)
+			if tc.expectError == nil && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.expectError != nil && !errors.Is(err, tc.expectError) {
+				t.Errorf("expected %v, got %v", tc.expectError, err)
+			}
+			if category, _ := ClassifyError(err); tc.expectError != nil && category != tc.expectCategory {
+				t.Errorf("expected category %s, got %s", tc.expectCategory, category)
+			}
+		})
+	}
+}
@@ -0,0 +1,407 @@
+package model
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+)
+
+// What happens to a prompt whose condition does not hold, unless it jumps to a later prompt.
+const (
+	otherwiseSkip = "skip" // Skip the prompt and go on with the next one
+	otherwiseStop = "stop" // Skip the prompt and all the following ones
+)
+
+// conditionToken matches the next token of a condition expression: a reference, a number,
+// a quoted string, an operator, a parenthesis or a name.
+var conditionToken = regexp.MustCompile(`^\s*(step\d+(?:\.[\w-]+|\[\d+\])*|-?\d+(?:\.\d+)?(?:[eE][-+]?\d+)?|"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'|==|!=|<=|>=|&&|\|\||[<>!()]|[A-Za-z_]\w*)`)
+
+// stepPath matches a reference to an earlier answer in a condition, such as step1.sites[0].name.
+var stepPath = regexp.MustCompile(`^step(\d+)((?:\.[^.\[\]{}\s]+|\[\d+\])*)$`)
+
+// jumpTarget matches an otherwise value naming the prompt to jump to.
+var jumpTarget = regexp.MustCompile(`^step(\d+)$`)
+
+// operand evaluates part of a condition against the earlier answers of a sequence.
+type operand func(answers map[int]string) any
+
+// promptCondition is the parsed condition of a prompt.
+type promptCondition struct {
+	holds     func(answers map[int]string) bool
+	steps     []int  // Sequence numbers of the answers the condition refers to
+	otherwise string // otherwiseSkip or otherwiseStop; empty when jumping
+	jumpTo    int    // Sequence number of the prompt to jump to
+}
+
+// parseConditions parses the conditions of the prompts of a sequence and checks that they only
+// refer to earlier answers and only jump forward, to a prompt of the sequence.
+//
+// Parameters:
+//   - prompts: The prompts of the sequence, in order.
+//
+// Returns:
+//   - The parsed condition of each prompt, nil for prompts without one.
+//   - An error wrapping ErrInvalidCondition for the first condition that is not valid.
+func parseConditions(prompts []definitions.Prompt) ([]*promptCondition, error) {
+	conditions := make([]*promptCondition, len(prompts))
+	for i, prompt := range prompts {
+		if prompt.Condition == nil {
+			continue
+		}
+		condition, err := parseCondition(*prompt.Condition)
+		if err == nil {
+			err = checkSteps(condition, prompts[i:])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: prompt %d: %v", ErrInvalidCondition, prompt.SequenceNumber, err)
+		}
+		conditions[i] = condition
+	}
+	return conditions, nil
+}
+
+// ValidateConditions checks the conditions of the prompts of a sequence before it is run: they
+// must parse, only refer to earlier answers, and only jump forward to a prompt of the sequence.
+//
+// Parameters:
+//   - prompts: The prompts of the sequence, sorted by sequence number.
+//
+// Returns:
+//   - An error wrapping ErrInvalidCondition for the first condition that is not valid, or nil.
+func ValidateConditions(prompts []definitions.Prompt) error {
+	_, err := parseConditions(prompts)
+	return err
+}
+
+// checkSteps checks the steps a condition refers to and jumps to against the prompt it belongs
+// to, the first of the given prompts.
+func checkSteps(condition *promptCondition, prompts []definitions.Prompt) error {
+	current := prompts[0].SequenceNumber
+	for _, step := range condition.steps {
+		if step >= current {
+			return fmt.Errorf("step%d is not answered before this prompt", step)
+		}
+	}
+	if condition.otherwise != "" {
+		return nil
+	}
+	for _, prompt := range prompts[1:] {
+		if prompt.SequenceNumber == condition.jumpTo {
+			return nil
+		}
+	}
+	return fmt.Errorf("step%d is not a later prompt of the sequence", condition.jumpTo)
+}
+
+// parseCondition parses an expression or path condition.
+func parseCondition(condition definitions.Condition) (*promptCondition, error) {
+	parsed := &promptCondition{otherwise: cmp.Or(condition.Otherwise, otherwiseSkip)}
+	if groups := jumpTarget.FindStringSubmatch(condition.Otherwise); groups != nil {
+		parsed.otherwise = ""
+		parsed.jumpTo, _ = strconv.Atoi(groups[1])
+	} else if parsed.otherwise != otherwiseSkip && parsed.otherwise != otherwiseStop {
+		return nil, fmt.Errorf("otherwise must be skip, stop or stepN, not %q", condition.Otherwise)
+	}
+
+	switch {
+	case condition.Expression != "" && condition.Path != "":
+		return nil, errors.New("set either an expression or a path, not both")
+	case condition.Expression != "":
+		parser := &conditionParser{}
+		if err := parser.tokenize(condition.Expression); err != nil {
+			return nil, err
+		}
+		evaluate, err := parser.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if token := parser.peek(); token != "" {
+			return nil, fmt.Errorf("unexpected %q", token)
+		}
+		parsed.steps = parser.steps
+		parsed.holds = func(answers map[int]string) bool { return truthy(evaluate(answers)) }
+	case condition.Path != "":
+		if condition.Equals == nil {
+			return nil, errors.New("a path needs a value to equal")
+		}
+		evaluate, step, err := referenceOperand(condition.Path)
+		if err != nil {
+			return nil, err
+		}
+		decoder := json.NewDecoder(strings.NewReader(string(condition.Equals)))
+		decoder.UseNumber()
+		var expected any
+		if err := decoder.Decode(&expected); err != nil {
+			return nil, fmt.Errorf("equals is not JSON: %v", err)
+		}
+		parsed.steps = []int{step}
+		parsed.holds = func(answers map[int]string) bool { return equalValues(evaluate(answers), expected) }
+	default:
+		return nil, errors.New("set an expression or a path")
+	}
+	return parsed, nil
+}
+
+// referenceOperand parses a reference to an earlier answer. It evaluates to the value at the
+// path, or to the whole answer without a path (as text if it is not JSON), and to nil when the
+// step was not answered or the path does not exist.
+func referenceOperand(reference string) (operand, int, error) {
+	groups := stepPath.FindStringSubmatch(reference)
+	if groups == nil {
+		return nil, 0, fmt.Errorf("%q is not a reference such as step1.field", reference)
+	}
+	step, _ := strconv.Atoi(groups[1])
+	path := groups[2]
+	return func(answers map[int]string) any {
+		answer, exists := answers[step]
+		if !exists {
+			return nil
+		}
+		value, err := lookupPath(answer, path)
+		if err != nil && path == "" {
+			return answer
+		}
+		return value
+	}, step, nil
+}
+
+// conditionParser parses condition expressions by recursive descent. In order of precedence,
+// expressions combine comparisons (==, !=, <, <=, >, >=) of references and literals with !, &&
+// and ||; parentheses group them.
+type conditionParser struct {
+	tokens   []string
+	position int
+	steps    []int // Sequence numbers of the references found
+}
+
+// tokenize splits an expression into tokens.
+func (p *conditionParser) tokenize(expression string) error {
+	rest := expression
+	for strings.TrimSpace(rest) != "" {
+		match := conditionToken.FindStringSubmatch(rest)
+		if match == nil {
+			return fmt.Errorf("unexpected %q", strings.TrimSpace(rest))
+		}
+		p.tokens = append(p.tokens, match[1])
+		rest = rest[len(match[0]):]
+	}
+	return nil
+}
+
+// peek returns the next token, or empty at the end of the expression.
+func (p *conditionParser) peek() string {
+	if p.position < len(p.tokens) {
+		return p.tokens[p.position]
+	}
+	return ""
+}
+
+// next consumes the next token.
+func (p *conditionParser) next() string {
+	token := p.peek()
+	p.position++
+	return token
+}
+
+// parseOr parses operands joined by ||.
+func (p *conditionParser) parseOr() (operand, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		first := left
+		left = func(answers map[int]string) any { return truthy(first(answers)) || truthy(right(answers)) }
+	}
+	return left, nil
+}
+
+// parseAnd parses operands joined by &&.
+func (p *conditionParser) parseAnd() (operand, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		first := left
+		left = func(answers map[int]string) any { return truthy(first(answers)) && truthy(right(answers)) }
+	}
+	return left, nil
+}
+
+// parseNot parses an operand, possibly negated with !.
+func (p *conditionParser) parseNot() (operand, error) {
+	if p.peek() != "!" {
+		return p.parseComparison()
+	}
+	p.next()
+	negated, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	return func(answers map[int]string) any { return !truthy(negated(answers)) }, nil
+}
+
+// parseComparison parses an operand, possibly compared with another one.
+func (p *conditionParser) parseComparison() (operand, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	operator := p.peek()
+	switch operator {
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.next()
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return func(answers map[int]string) any { return compareValues(operator, left(answers), right(answers)) }, nil
+	}
+	return left, nil
+}
+
+// parseOperand parses a reference, a literal or a parenthesized expression.
+func (p *conditionParser) parseOperand() (operand, error) {
+	token := p.next()
+	switch {
+	case token == "":
+		return nil, errors.New("unexpected end of expression")
+	case token == "(":
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, errors.New("missing )")
+		}
+		return inner, nil
+	case stepPath.MatchString(token):
+		reference, step, err := referenceOperand(token)
+		p.steps = append(p.steps, step)
+		return reference, err
+	case token[0] == '"':
+		text, err := strconv.Unquote(token)
+		return constant(text), err
+	case token[0] == '\'':
+		return constant(strings.ReplaceAll(token[1:len(token)-1], `\'`, `'`)), nil
+	case token == "true" || token == "false":
+		return constant(token == "true"), nil
+	case token == "null":
+		return constant(nil), nil
+	}
+	if _, err := strconv.ParseFloat(token, 64); err == nil {
+		return constant(json.Number(token)), nil
+	}
+	return nil, fmt.Errorf("unexpected %q", token)
+}
+
+// constant returns an operand that always evaluates to the given value.
+func constant(value any) operand {
+	return func(map[int]string) any { return value }
+}
+
+// normalized converts the JSON numbers in a value to float64 so that values can be compared.
+func normalized(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		if number, err := v.Float64(); err == nil {
+			return number
+		}
+		return v.String()
+	case []any:
+		elements := make([]any, len(v))
+		for i, element := range v {
+			elements[i] = normalized(element)
+		}
+		return elements
+	case map[string]any:
+		fields := make(map[string]any, len(v))
+		for name, field := range v {
+			fields[name] = normalized(field)
+		}
+		return fields
+	}
+	return value
+}
+
+// equalValues reports whether two JSON values are equal, comparing numbers by value.
+func equalValues(left any, right any) bool {
+	return reflect.DeepEqual(normalized(left), normalized(right))
+}
+
+// compareValues applies a comparison operator. Numbers and strings are ordered; ordering
+// other values, or values of different types, is false.
+func compareValues(operator string, left any, right any) bool {
+	switch operator {
+	case "==":
+		return equalValues(left, right)
+	case "!=":
+		return !equalValues(left, right)
+	}
+
+	var order int
+	switch l := normalized(left).(type) {
+	case float64:
+		r, ok := normalized(right).(float64)
+		if !ok {
+			return false
+		}
+		order = cmp.Compare(l, r)
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return false
+		}
+		order = strings.Compare(l, r)
+	default:
+		return false
+	}
+	switch operator {
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	default:
+		return order >= 0
+	}
+}
+
+// truthy reports whether a value counts as true on its own: false, null, zero, empty strings,
+// empty arrays and empty objects do not.
+func truthy(value any) bool {
+	switch v := normalized(value).(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case []any:
+		return len(v) > 0
+	case map[string]any:
+		return len(v) > 0
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+)
+
+func TestConditionHolds(t *testing.T) {
+	answers := map[int]string{
+		1: `{"included": false, "score": 3.5, "design": "RCT", "sites": [{"name": "Bonaire"}], "notes": []}`,
+		2: "Not relevant.",
+	}
+
+	tests := []struct {
+		name      string
+		condition definitions.Condition
+		expect    bool
+	}{
+		{name: "Boolean equality", condition: definitions.Condition{Expression: "step1.included == false"}, expect: true},
+		{name: "Negated reference", condition: definitions.Condition{Expression: "!step1.included"}, expect: true},
+		{name: "Numeric comparison", condition: definitions.Condition{Expression: "step1.score >= 3"}, expect: true},
+		{name: "Numeric comparison false", condition: definitions.Condition{Expression: "step1.score > 3.5"}, expect: false},
+		{name: "Single-quoted string", condition: definitions.Condition{Expression: "step1.design == 'RCT' && step1.score < 4"}, expect: true},
+		{name: "Double-quoted string", condition: definitions.Condition{Expression: `step1.sites[0].name != "Curaçao"`}, expect: true},
+		{name: "Parentheses", condition: definitions.Condition{Expression: "(step1.included || step1.score > 5) && step1.design == 'RCT'"}, expect: false},
+		{name: "Empty array is false", condition: definitions.Condition{Expression: "step1.notes"}, expect: false},
+		{name: "Missing field is null", condition: definitions.Condition{Expression: "step1.country == null"}, expect: true},
+		{name: "Text answer", condition: definitions.Condition{Expression: "step2 == 'Not relevant.'"}, expect: true},
+		{name: "Mismatched types are not ordered", condition: definitions.Condition{Expression: "step1.design > 1"}, expect: false},
+		{name: "Path equality", condition: definitions.Condition{Path: "step1.included", Equals: json.RawMessage(`false`)}, expect: true},
+		{name: "Path equality on a number", condition: definitions.Condition{Path: "step1.score", Equals: json.RawMessage(`3.50`)}, expect: true},
+		{name: "Path equality on an array", condition: definitions.Condition{Path: "step1.sites", Equals: json.RawMessage(`[{"name": "Bonaire"}]`)}, expect: true},
+		{name: "Path equality false", condition: definitions.Condition{Path: "step1.design", Equals: json.RawMessage(`"cohort"`)}, expect: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			parsed, err := parseCondition(tc.condition)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if holds := parsed.holds(answers); holds != tc.expect {
+				t.Errorf("expected %v, got %v", tc.expect, holds)
+			}
+		})
+	}
+}
+
+func TestParseConditionsRejectsInvalidConditions(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition definitions.Condition
+	}{
+		{name: "Unknown name", condition: definitions.Condition{Expression: "included == true"}},
+		{name: "Missing operand", condition: definitions.Condition{Expression: "step1.included =="}},
+		{name: "Unbalanced parenthesis", condition: definitions.Condition{Expression: "(step1.included"}},
+		{name: "Trailing token", condition: definitions.Condition{Expression: "step1.included true"}},
+		{name: "Unknown character", condition: definitions.Condition{Expression: "step1.score + 1"}},
+		{name: "Expression and path", condition: definitions.Condition{Expression: "step1.included", Path: "step1.included", Equals: json.RawMessage(`true`)}},
+		{name: "Path without value", condition: definitions.Condition{Path: "step1.included"}},
+		{name: "Path not a reference", condition: definitions.Condition{Path: "included", Equals: json.RawMessage(`true`)}},
+		{name: "Neither", condition: definitions.Condition{Otherwise: "stop"}},
+		{name: "Unknown otherwise", condition: definitions.Condition{Expression: "step1.included", Otherwise: "exit"}},
+		{name: "Reference to the same step", condition: definitions.Condition{Expression: "step2.included"}},
+		{name: "Jump backwards", condition: definitions.Condition{Expression: "step1.included", Otherwise: "step1"}},
+		{name: "Jump to a missing step", condition: definitions.Condition{Expression: "step1.included", Otherwise: "step9"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			prompts := promptsOf("First", "Second", "Third")
+			prompts[1].Condition = &tc.condition
+			if _, err := parseConditions(prompts); !errors.Is(err, ErrInvalidCondition) {
+				t.Errorf("expected ErrInvalidCondition, got %v", err)
+			}
+		})
+	}
+}
@@ -12,39 +12,63 @@ import (
 	"github.com/cohesion-org/deepseek-go/constants"
 )
 
-func queryDeepSeek(prompts []string, llm definitions.Model) ([]string, error) {
-	answers := []string{}
-
+func queryDeepSeek(ctx context.Context, prompts []definitions.Prompt, llm definitions.Model) ([]Answer, error) {
 	client := deepseek.NewClient(llm.APIKey)
-	messages := []deepseek.ChatCompletionMessage{}
 
-	for i, prompt := range prompts {
-		messages = append(messages, deepseek.ChatCompletionMessage{Role: constants.ChatMessageRoleUser, Content: prompt})
+	// Output token limit depend on model, unless the model configuration sets one
+	maxTokens := 8192
 
-		// Output token limit depend on model
-		maxTokens := 8192
+	if llm.Model == "deepseek-reasoner" {
+		maxTokens = 64000
+	}
+	if llm.MaxOutputTokens > 0 {
+		maxTokens = llm.MaxOutputTokens
+	}
+	topP := float32(1.0)
+	if llm.TopP != nil {
+		topP = float32(*llm.TopP)
+	}
 
-		if llm.Model == "deepseek-reasoner" {
-			maxTokens = 64000
+	return runSequence(ctx, prompts, llm, func(ctx context.Context, request completion) ([]string, error) {
+		messages := []deepseek.ChatCompletionMessage{}
+		if request.system != "" {
+			messages = append(messages, deepseek.ChatCompletionMessage{Role: constants.ChatMessageRoleSystem, Content: request.system})
+		}
+		for _, m := range request.messages {
+			role := constants.ChatMessageRoleUser
+			if m.role == roleAssistant {
+				role = constants.ChatMessageRoleAssistant
+			}
+			messages = append(messages, deepseek.ChatCompletionMessage{Role: role, Content: m.content})
 		}
 
 		completionParams := &deepseek.ChatCompletionRequest{
-			Model:          llm.Model,
-			Messages:       messages,
-			ResponseFormat: &deepseek.ResponseFormat{Type: "json_object"},
-			TopP:           float32(1.0),
-			MaxTokens:      maxTokens,
-			Temperature:    float32(llm.Temperature),
+			Model:       llm.Model,
+			Messages:    messages,
+			TopP:        topP,
+			MaxTokens:   maxTokens,
+			Temperature: float32(llm.Temperature),
+			Stop:        llm.Stop,
+		}
+		// DeepSeek has JSON mode only, which also serves prompts with a response schema
+		if request.format != formatText {
+			completionParams.ResponseFormat = &deepseek.ResponseFormat{Type: "json_object"}
+		}
+		if llm.PresencePenalty != nil {
+			completionParams.PresencePenalty = float32(*llm.PresencePenalty)
+		}
+		if llm.FrequencyPenalty != nil {
+			completionParams.FrequencyPenalty = float32(*llm.FrequencyPenalty)
 		}
 
-		resp, err := client.CreateChatCompletion(context.Background(), completionParams)
+		resp, err := client.CreateChatCompletion(ctx, completionParams)
 		if err != nil {
 			if apiErr, ok := err.(*deepseek.APIError); ok {
 				logger.Error(fmt.Sprintf("API Error: HTTP %d, Code %d, Message: %s", apiErr.StatusCode, apiErr.APICode, apiErr.Message))
 			} else {
 				logger.Error(fmt.Sprintf("Unexpected error: %v", err))
 			}
-			return nil, fmt.Errorf("no response from deepseek: %v", err)
+			return nil, fmt.Errorf("no response from deepseek: %w", err)
 		}
 
 		respJSON, err := json.MarshalIndent(resp, "", "  ")
@@ -54,20 +78,15 @@ func queryDeepSeek(prompts []string, llm definitions.Model) ([]string, error) {
 		}
 		logger.Info(fmt.Sprintf("Full deepseek response: %s", string(respJSON)))
 
+		if len(resp.Choices) > 0 && resp.Choices[0].FinishReason == "content_filter" {
+			logger.Error("Response blocked by content filter")
+			return nil, fmt.Errorf("%w (deepseek)", ErrContentFiltered)
+		}
 		if len(resp.Choices) == 0 || resp.Choices[0].Message.Content == "" {
 			logger.Error("No content found in response")
 			return nil, fmt.Errorf("no content in response")
 		}
 
-		answer := resp.Choices[0].Message.Content
-		answers = append(answers, answer)
-		messages = append(messages, deepseek.ChatCompletionMessage{Role: constants.ChatMessageRoleAssistant, Content: answer})
-
-		// Call wait for all prompts except the last one
-		if i < len(prompts)-1 {
-			Wait(prompt, llm)
-		}
-	}
-
-	return answers, nil
+		return []string{resp.Choices[0].Message.Content}, nil
+	})
 }
@@ -9,10 +9,13 @@ Supported LLM Providers:
   - Anthropic (Claude-3.5-Sonnet, Claude-3-Haiku, Claude-3-Opus)
   - DeepSeek (DeepSeek-Chat)
   - Perplexity (Sonar, Sonar Pro, Sonar Reasoning Pro)
+  - Mistral (Mistral Large, Mistral Medium, Mistral Small, Magistral, Ministral, Codestral)
   - AWSBedrock (Llama variants via Bedrock)
   - AzureAI (Azure OpenAI deployments)
   - VertexAI (Llama variants via Vertex Model Garden)
   - SelfHosted (OpenAI-compatible endpoints)
+  - Ollama (native chat API of an Ollama server)
+  - Any provider registered with RegisterProvider
 
 Core Functions:
   - QueryLLM: A generic interface for querying different LLMs.
@@ -25,15 +28,25 @@ Core Functions:
 
 Features:
   - Supports multi-turn chat history for context-aware responses.
-  - Ensures all responses are in structured JSON format.
+  - Sends the system prompt of the model in each provider's native system role.
+  - Sends few-shot examples as earlier user and assistant turns before their prompt.
+  - Resolves {{stepN.path}} references to earlier answers, with or without the chat history.
+  - Skips prompts, stops sequences or jumps ahead when prompt conditions over earlier answers do not hold.
+  - Maps the generation parameters of the model to each provider and rejects unsupported ones.
+  - Samples several completions per prompt, natively where the provider supports it.
+  - Ensures responses are in structured JSON format, unless a prompt asks for free text.
+  - Enforces per-prompt response schemas natively and validates every answer against them.
   - Implements automatic model selection and error handling.
   - Enforces API rate limits using Wait function.
+  - Retries transient errors with exponential backoff when the model has a retry policy.
+  - Honors context cancellation and deadlines in provider calls and rate-limit waits.
 
 Example Usage:
 
 	package main
 
 	import (
+		"context"
 		"fmt"
 		"github.com/open-and-sustainable/alembica/definitions"
 		"github.com/open-and-sustainable/alembica/model"
@@ -47,15 +60,18 @@ Example Usage:
 			Temperature: 0.7,
 		}
 
-		prompts := []string{"Hello, AI!", "What is the capital of France?"}
-		answers, err := model.DefaultQueryService{}.QueryLLM(prompts, llm)
+		prompts := []definitions.Prompt{
+			{PromptContent: "Hello, AI!", SequenceID: "1", SequenceNumber: 1},
+			{PromptContent: "What is the capital of France?", SequenceID: "1", SequenceNumber: 2},
+		}
+		answers, err := model.DefaultQueryService{}.QueryLLM(context.Background(), prompts, llm)
 		if err != nil {
 			fmt.Println("Error:", err)
 			return
 		}
 
 		for i, answer := range answers {
-			fmt.Printf("Response %d: %s\n", i+1, answer)
+			fmt.Printf("Response %d: %s\n", i+1, answer.Responses[0])
 		}
 	}
 */
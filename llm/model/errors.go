@@ -0,0 +1,221 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	cohereCore "github.com/cohere-ai/cohere-go/v2/core"
+	deepseek "github.com/cohesion-org/deepseek-go"
+	"github.com/open-and-sustainable/alembica/definitions"
+	"github.com/openai/openai-go/v3"
+	"google.golang.org/genai"
+)
+
+// ErrorCategory classifies why a provider query failed.
+type ErrorCategory string
+
+const (
+	ErrorAuth          ErrorCategory = "auth"           // Missing, invalid or unauthorized credentials.
+	ErrorRateLimit     ErrorCategory = "rate-limit"     // Provider throttled the request.
+	ErrorContextLength ErrorCategory = "context-length" // Prompt exceeded the model context window.
+	ErrorContentFilter ErrorCategory = "content-filter" // Provider refused or filtered the content.
+	ErrorInvalidJSON   ErrorCategory = "invalid-json"   // Model answer did not contain valid JSON.
+	ErrorTimeout       ErrorCategory = "timeout"        // Request or deadline timed out.
+	ErrorProvider      ErrorCategory = "provider-5xx"   // Provider-side server error.
+	ErrorNetwork       ErrorCategory = "network"        // Connection reset, refused or cut before a response.
+	ErrorUnknown       ErrorCategory = "unknown"        // Any other failure.
+)
+
+// Sentinel errors wrapped by providers when they detect the condition themselves.
+var (
+	ErrInvalidJSON     = errors.New("no valid JSON in response")
+	ErrSchemaMismatch  = errors.New("response does not match the response schema")
+	ErrContentFiltered = errors.New("response blocked by content filter")
+	// ErrGenerationFailed is returned when a provider reports a server-side failure in its answer.
+	ErrGenerationFailed = errors.New("generation failed on the provider side")
+	// ErrGenerationTimeout is returned when a provider reports that the generation timed out.
+	ErrGenerationTimeout = errors.New("generation timed out on the provider side")
+	// ErrUnsupportedParameter is returned by ValidateParameters.
+	ErrUnsupportedParameter = errors.New("unsupported generation parameter")
+	// ErrUnresolvedReference is returned when a prompt refers to an earlier answer that does not exist.
+	ErrUnresolvedReference = errors.New("unresolved reference to an earlier answer")
+	// ErrInvalidCondition is returned when the condition of a prompt cannot be parsed.
+	ErrInvalidCondition = errors.New("invalid prompt condition")
+)
+
+// defaultErrorCodes maps categories to the HTTP-like code reported when the provider gave none.
+var defaultErrorCodes = map[ErrorCategory]int{
+	ErrorAuth:          http.StatusUnauthorized,
+	ErrorRateLimit:     http.StatusTooManyRequests,
+	ErrorContextLength: http.StatusRequestEntityTooLarge,
+	ErrorContentFilter: http.StatusUnavailableForLegalReasons,
+	ErrorInvalidJSON:   http.StatusUnprocessableEntity,
+	ErrorTimeout:       http.StatusGatewayTimeout,
+	ErrorProvider:      http.StatusBadGateway,
+	ErrorNetwork:       http.StatusServiceUnavailable,
+	ErrorUnknown:       http.StatusInternalServerError,
+}
+
+var contextLengthMarkers = []string{
+	"context_length_exceeded",
+	"context length",
+	"context window",
+	"maximum context",
+	"prompt is too long",
+	"input is too long",
+	"too many tokens",
+	"maximum number of tokens",
+}
+
+var networkMarkers = []string{
+	"connection reset",
+	"connection refused",
+	"broken pipe",
+	"unexpected eof",
+}
+
+var contentFilterMarkers = []string{
+	"content_filter",
+	"content filter",
+	"content management policy",
+	"safety",
+	"prohibited content",
+}
+
+// ClassifyError inspects an error returned by a provider query and assigns it a category.
+//
+// Parameters:
+//   - err: The error to classify.
+//
+// Returns:
+//   - The error category.
+//   - An HTTP-like status code: the provider status when available, otherwise a default for the category.
+func ClassifyError(err error) (ErrorCategory, int) {
+	if err == nil {
+		return "", 0
+	}
+
+	status := statusCodeOf(err)
+	category := categoryOf(err, status)
+	if status == 0 {
+		status = defaultErrorCodes[category]
+	}
+	return category, status
+}
+
+// DescribeError converts a provider error into the categorized error reported in the output.
+//
+// Parameters:
+//   - err: The error to describe.
+//
+// Returns:
+//   - The error code, category and message, or nil if err is nil.
+func DescribeError(err error) *definitions.ErrorInfo {
+	if err == nil {
+		return nil
+	}
+	category, code := ClassifyError(err)
+	return &definitions.ErrorInfo{
+		Code:     code,
+		Category: string(category),
+		Message:  err.Error(),
+	}
+}
+
+func categoryOf(err error, status int) ErrorCategory {
+	switch {
+	case errors.Is(err, ErrInvalidJSON), errors.Is(err, ErrSchemaMismatch):
+		return ErrorInvalidJSON
+	case errors.Is(err, ErrContentFiltered):
+		return ErrorContentFilter
+	case errors.Is(err, ErrGenerationFailed):
+		return ErrorProvider
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, ErrGenerationTimeout):
+		return ErrorTimeout
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrorTimeout
+	}
+
+	message := strings.ToLower(err.Error())
+	if status == 0 && isNetworkError(err, message) {
+		return ErrorNetwork
+	}
+
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrorAuth
+	case status == http.StatusTooManyRequests:
+		return ErrorRateLimit
+	case status == http.StatusRequestTimeout || status == http.StatusGatewayTimeout:
+		return ErrorTimeout
+	case status == http.StatusRequestEntityTooLarge || containsAny(message, contextLengthMarkers):
+		return ErrorContextLength
+	case containsAny(message, contentFilterMarkers):
+		return ErrorContentFilter
+	case status >= 500:
+		return ErrorProvider
+	}
+	return ErrorUnknown
+}
+
+// isNetworkError reports whether the request failed at the connection level, before any HTTP status was received.
+func isNetworkError(err error, message string) bool {
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &opErr):
+		return true
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.EPIPE):
+		return true
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	}
+	return containsAny(message, networkMarkers)
+}
+
+// statusCodeOf extracts the HTTP status code carried by the provider SDK errors, or 0 if none.
+func statusCodeOf(err error) int {
+	var openaiErr *openai.Error
+	if errors.As(err, &openaiErr) {
+		return openaiErr.StatusCode
+	}
+	var anthropicErr *anthropic.Error
+	if errors.As(err, &anthropicErr) {
+		return anthropicErr.StatusCode
+	}
+	var genaiErr genai.APIError
+	if errors.As(err, &genaiErr) {
+		return genaiErr.Code
+	}
+	var deepseekErr *deepseek.APIError
+	if errors.As(err, &deepseekErr) {
+		return deepseekErr.StatusCode
+	}
+	var cohereErr *cohereCore.APIError
+	if errors.As(err, &cohereErr) {
+		return cohereErr.StatusCode
+	}
+	// AWS SDK and Ollama errors expose the status through a method.
+	var awsErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &awsErr) {
+		return awsErr.HTTPStatusCode()
+	}
+	return 0
+}
+
+func containsAny(s string, markers []string) bool {
+	for _, marker := range markers {
+		if strings.Contains(s, marker) {
+			return true
+		}
+	}
+	return false
+}
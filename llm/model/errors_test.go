@@ -0,0 +1,105 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+
+	cohereCore "github.com/cohere-ai/cohere-go/v2/core"
+	deepseek "github.com/cohesion-org/deepseek-go"
+	"github.com/openai/openai-go/v3"
+	"google.golang.org/genai"
+)
+
+func TestClassifyError(t *testing.T) {
+	openAIErr := &openai.Error{
+		StatusCode: http.StatusUnauthorized,
+		Request:    httptest.NewRequest(http.MethodPost, "https://api.openai.com/v1/chat/completions", nil),
+		Response:   &http.Response{StatusCode: http.StatusUnauthorized},
+	}
+
+	tests := []struct {
+		name         string
+		err          error
+		wantCategory ErrorCategory
+		wantCode     int
+	}{
+		{
+			name:         "OpenAI unauthorized",
+			err:          fmt.Errorf("no response from OpenAI: %w", openAIErr),
+			wantCategory: ErrorAuth,
+			wantCode:     http.StatusUnauthorized,
+		},
+		{
+			name:         "Cohere rate limit",
+			err:          fmt.Errorf("[Cohere] API error: %w", cohereCore.NewAPIError(http.StatusTooManyRequests, nil, errors.New("too many requests"))),
+			wantCategory: ErrorRateLimit,
+			wantCode:     http.StatusTooManyRequests,
+		},
+		{
+			name:         "Gemini server error",
+			err:          fmt.Errorf("the Google AI response error: %w", genai.APIError{Code: http.StatusServiceUnavailable, Message: "overloaded"}),
+			wantCategory: ErrorProvider,
+			wantCode:     http.StatusServiceUnavailable,
+		},
+		{
+			name:         "DeepSeek context length",
+			err:          fmt.Errorf("no response from deepseek: %w", &deepseek.APIError{StatusCode: http.StatusBadRequest, Message: "This model's maximum context length is 65536 tokens"}),
+			wantCategory: ErrorContextLength,
+			wantCode:     http.StatusBadRequest,
+		},
+		{
+			name:         "Content filter sentinel",
+			err:          fmt.Errorf("%w (Anthropic)", ErrContentFiltered),
+			wantCategory: ErrorContentFilter,
+			wantCode:     http.StatusUnavailableForLegalReasons,
+		},
+		{
+			name:         "Invalid JSON sentinel",
+			err:          fmt.Errorf("%w from Anthropic: start delimiter not found", ErrInvalidJSON),
+			wantCategory: ErrorInvalidJSON,
+			wantCode:     http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "Deadline exceeded",
+			err:          fmt.Errorf("no response from OpenAI: %w", context.DeadlineExceeded),
+			wantCategory: ErrorTimeout,
+			wantCode:     http.StatusGatewayTimeout,
+		},
+		{
+			name:         "Connection refused",
+			err:          fmt.Errorf("no response from SelfHosted: %w", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}),
+			wantCategory: ErrorNetwork,
+			wantCode:     http.StatusServiceUnavailable,
+		},
+		{
+			name:         "Connection reset message",
+			err:          errors.New("read tcp 10.0.0.1:443: connection reset by peer"),
+			wantCategory: ErrorNetwork,
+			wantCode:     http.StatusServiceUnavailable,
+		},
+		{
+			name:         "Unrecognized error",
+			err:          errors.New("something odd happened"),
+			wantCategory: ErrorUnknown,
+			wantCode:     http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			category, code := ClassifyError(tc.err)
+			if category != tc.wantCategory {
+				t.Errorf("expected category %q, got %q", tc.wantCategory, category)
+			}
+			if code != tc.wantCode {
+				t.Errorf("expected code %d, got %d", tc.wantCode, code)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/open-and-sustainable/alembica/definitions"
 	"github.com/open-and-sustainable/alembica/utils/logger"
@@ -11,12 +12,7 @@ import (
 	"google.golang.org/genai"
 )
 
-func queryGoogleAI(prompts []string, llm definitions.Model) ([]string, error) {
-	answers := []string{}
-
-	// Create a new context for API calls
-	ctx := context.Background()
-
+func queryGoogleAI(ctx context.Context, prompts []definitions.Prompt, llm definitions.Model) ([]Answer, error) {
 	// Create a new Google Gemini API client using the API key
 	client, err := genai.NewClient(ctx, &genai.ClientConfig{
 		APIKey:  llm.APIKey,
@@ -30,71 +26,152 @@ func queryGoogleAI(prompts []string, llm definitions.Model) ([]string, error) {
 	// Log selected model
 	logger.Info(fmt.Sprintf("[GoogleAI] Using model: %s", llm.Model))
 
-	// Configure the generative model
-	config := &genai.GenerateContentConfig{
-		Temperature:      genai.Ptr(float32(llm.Temperature)),
-		CandidateCount:   1,
-		ResponseMIMEType: "application/json",
-	}
+	return runSequence(ctx, prompts, llm, genaiCompleter(client, llm, "GoogleAI", "Google AI"))
+}
 
-	// Start a new chat session; history is maintained automatically by SendMessage
-	cs, err := client.Chats.Create(ctx, llm.Model, config, nil)
-	if err != nil {
-		logger.Error(fmt.Sprintf("[GoogleAI] Failed to create chat: %v", err))
-		return nil, err
-	}
+// genaiCompleter returns a completer for the Gemini content generation API, shared by the
+// GoogleAI and VertexAI providers. The conversation history is sent with every request and
+// samples are requested as candidates.
+//
+// Parameters:
+//   - client: The client configured for the backend.
+//   - llm: The model configuration.
+//   - tag: The provider tag used in logs.
+//   - name: The provider name used in errors.
+//
+// Returns:
+//   - The completer.
+func genaiCompleter(client *genai.Client, llm definitions.Model, tag string, name string) completer {
+	calls := 0
+	return func(ctx context.Context, request completion) ([]string, error) {
+		calls++
+		contents := []*genai.Content{}
+		for _, m := range request.messages {
+			role := genai.Role(genai.RoleUser)
+			if m.role == roleAssistant {
+				role = genai.RoleModel
+			}
+			contents = append(contents, genai.NewContentFromText(m.content, role))
+		}
 
-	// Loop over prompts while maintaining chat history
-	for i, prompt := range prompts {
-		logger.Info(fmt.Sprintf("[GoogleAI] Sending prompt #%d: %s", i+1, prompt))
+		// Configure the generative model
+		config := &genai.GenerateContentConfig{
+			Temperature:     genai.Ptr(float32(llm.Temperature)),
+			CandidateCount:  int32(max(request.samples, 1)),
+			MaxOutputTokens: int32(llm.MaxOutputTokens),
+			StopSequences:   llm.Stop,
+		}
+		if llm.TopP != nil {
+			config.TopP = genai.Ptr(float32(*llm.TopP))
+		}
+		if llm.TopK > 0 {
+			config.TopK = genai.Ptr(float32(llm.TopK))
+		}
+		if llm.Seed != nil {
+			config.Seed = genai.Ptr(int32(*llm.Seed))
+		}
+		if llm.PresencePenalty != nil {
+			config.PresencePenalty = genai.Ptr(float32(*llm.PresencePenalty))
+		}
+		if llm.FrequencyPenalty != nil {
+			config.FrequencyPenalty = genai.Ptr(float32(*llm.FrequencyPenalty))
+		}
+		if len(llm.ExtraParams) > 0 {
+			config.HTTPOptions = &genai.HTTPOptions{ExtraBody: llm.ExtraParams}
+		}
+		if request.format != formatText {
+			config.ResponseMIMEType = "application/json"
+		}
+		if request.system != "" {
+			config.SystemInstruction = genai.NewContentFromText(request.system, genai.RoleUser)
+		}
+		if request.responseSchema != nil {
+			schema, err := schemaObject(request.responseSchema)
+			if err != nil {
+				return nil, err
+			}
+			config.ResponseJsonSchema = schema
+		}
+
+		logger.Info(fmt.Sprintf("[%s] Sending request #%d: %s", tag, calls, request.messages[len(request.messages)-1].content))
 
-		// Send message to model
-		resp, err := cs.SendMessage(ctx, genai.Part{Text: prompt})
+		resp, err := client.Models.GenerateContent(ctx, llm.Model, contents, config)
 		if err != nil {
-			logger.Error(fmt.Sprintf("[GoogleAI] Error on prompt #%d: %v", i+1, err))
-			return nil, fmt.Errorf("the Google AI response error: %v", err)
+			logger.Error(fmt.Sprintf("[%s] Error on request #%d: %v", tag, calls, err))
+			return nil, fmt.Errorf("the %s response error: %w", name, err)
+		}
+
+		// Stop if the prompt or every answer was blocked by safety filters
+		if blockedBySafety(resp) {
+			logger.Error(fmt.Sprintf("[%s] Response blocked by safety filters for request #%d", tag, calls))
+			return nil, fmt.Errorf("%w (%s)", ErrContentFiltered, name)
 		}
 
 		// Ensure response contains candidates
 		if len(resp.Candidates) == 0 {
-			logger.Error(fmt.Sprintf("[GoogleAI] No candidates received for prompt #%d", i+1))
-			return nil, fmt.Errorf("no candidates returned from Google AI")
+			logger.Error(fmt.Sprintf("[%s] No candidates received for request #%d", tag, calls))
+			return nil, fmt.Errorf("no candidates returned from %s", name)
 		}
 
 		// Log full response JSON
 		respJSON, err := json.MarshalIndent(resp, "", "  ")
 		if err != nil {
-			logger.Error(fmt.Sprintf("[GoogleAI] Failed to marshal response for prompt #%d: %v", i+1, err))
+			logger.Error(fmt.Sprintf("[%s] Failed to marshal response for request #%d: %v", tag, calls, err))
 			return nil, err
 		}
-		logger.Info(fmt.Sprintf("[GoogleAI] Full response for prompt #%d: %s", i+1, string(respJSON)))
-
-		// Extract content from first candidate
-		content := resp.Candidates[0].Content
-		if content == nil || len(content.Parts) == 0 {
-			logger.Error(fmt.Sprintf("[GoogleAI] No content parts in response for prompt #%d", i+1))
-			return nil, fmt.Errorf("no content in response")
+		logger.Info(fmt.Sprintf("[%s] Full response for request #%d: %s", tag, calls, string(respJSON)))
+
+		// Concatenate the text parts of each candidate
+		candidates := []string{}
+		for _, candidate := range resp.Candidates {
+			if text := candidateText(candidate); text != "" {
+				candidates = append(candidates, text)
+			}
 		}
 
-		// Concatenate all text parts of the response
-		resultText := resp.Text()
-
 		// Validate extracted text
-		if resultText == "" {
-			logger.Error(fmt.Sprintf("[GoogleAI] No text content extracted for prompt #%d", i+1))
-			return nil, fmt.Errorf("empty response from Google AI")
+		if len(candidates) == 0 {
+			logger.Error(fmt.Sprintf("[%s] No text content extracted for request #%d", tag, calls))
+			return nil, fmt.Errorf("empty response from %s", name)
 		}
+		return candidates, nil
+	}
+}
 
-		// Append response to answers
-		answers = append(answers, resultText)
-
-		logger.Info(fmt.Sprintf("[GoogleAI] Processed response for prompt #%d: %s", i+1, resultText))
+// candidateText concatenates the text parts of a candidate, skipping thoughts and candidates
+// blocked for safety reasons.
+func candidateText(candidate *genai.Candidate) string {
+	if candidate == nil || candidate.Content == nil {
+		return ""
+	}
+	switch candidate.FinishReason {
+	case genai.FinishReasonSafety, genai.FinishReasonProhibitedContent:
+		return ""
+	}
 
-		// Call wait for all prompts except the last one
-		if i < len(prompts)-1 {
-			Wait(prompt, llm)
+	var text strings.Builder
+	for _, part := range candidate.Content.Parts {
+		if part != nil && !part.Thought {
+			text.WriteString(part.Text)
 		}
 	}
+	return text.String()
+}
 
-	return answers, nil
+// blockedBySafety reports whether Gemini blocked the prompt or every candidate for safety reasons.
+func blockedBySafety(resp *genai.GenerateContentResponse) bool {
+	if resp.PromptFeedback != nil && resp.PromptFeedback.BlockReason != "" {
+		return true
+	}
+	if len(resp.Candidates) == 0 {
+		return false
+	}
+	for _, candidate := range resp.Candidates {
+		switch candidate.FinishReason {
+		case genai.FinishReasonSafety, genai.FinishReasonProhibitedContent:
+		default:
+			return false
+		}
+	}
+	return true
 }
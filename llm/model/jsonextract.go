@@ -0,0 +1,196 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ExtractedJSON is a JSON value found in the text of a model answer.
+type ExtractedJSON struct {
+	// JSON is the extracted value.
+	JSON string
+	// Repaired reports whether defects had to be fixed for the value to parse.
+	Repaired bool
+}
+
+// codeFence matches a markdown code block, optionally tagged with a language.
+var codeFence = regexp.MustCompile("(?s)```[a-zA-Z]*[ \t]*\r?\n?(.*?)```")
+
+// ExtractJSON finds the JSON value in the text of a model answer. The whole text is used
+// when it parses; otherwise the contents of markdown code fences and every balanced
+// {...} or [...] span are considered, and the largest one that parses is returned.
+// With repair enabled, spans that do not parse are fixed for trailing commas, single-quoted
+// strings and unescaped control characters in strings before being considered.
+//
+// Parameters:
+//   - text: The answer text.
+//   - repair: Whether defective JSON may be repaired.
+//
+// Returns:
+//   - The extracted value and whether it was repaired.
+//   - An error wrapping ErrInvalidJSON if the text holds no valid JSON value.
+func ExtractJSON(text string, repair bool) (ExtractedJSON, error) {
+	trimmed := strings.TrimSpace(text)
+	if json.Valid([]byte(trimmed)) {
+		return ExtractedJSON{JSON: trimmed}, nil
+	}
+
+	// Fenced blocks may hold any JSON value; elsewhere only objects and arrays are looked for
+	spans := []string{}
+	for _, match := range codeFence.FindAllStringSubmatch(trimmed, -1) {
+		spans = append(spans, strings.TrimSpace(match[1]))
+	}
+	spans = append(spans, balancedSpans(trimmed, repair)...)
+
+	var best ExtractedJSON
+	for _, span := range spans {
+		candidate, ok := parseSpan(span, repair)
+		if ok && len(candidate.JSON) > len(best.JSON) {
+			best = candidate
+		}
+	}
+	if best.JSON == "" {
+		return ExtractedJSON{}, fmt.Errorf("%w: no balanced JSON object or array found", ErrInvalidJSON)
+	}
+	return best, nil
+}
+
+// parseSpan checks whether a span is valid JSON, repairing it first if allowed and needed.
+func parseSpan(span string, repair bool) (ExtractedJSON, bool) {
+	if json.Valid([]byte(span)) {
+		return ExtractedJSON{JSON: span}, true
+	}
+	if !repair {
+		return ExtractedJSON{}, false
+	}
+	repaired := repairJSON(span)
+	if !json.Valid([]byte(repaired)) {
+		return ExtractedJSON{}, false
+	}
+	return ExtractedJSON{JSON: repaired, Repaired: true}, true
+}
+
+// balancedSpans returns the outermost spans of text that open with { or [ and close with the
+// matching bracket, ignoring brackets inside strings. Once a span parses, the spans nested in it
+// are skipped, since they are smaller; otherwise the scan resumes inside it.
+func balancedSpans(text string, repair bool) []string {
+	spans := []string{}
+	for start := 0; start < len(text); start++ {
+		if text[start] != '{' && text[start] != '[' {
+			continue
+		}
+		end := matchingBracket(text, start, repair)
+		if end < 0 {
+			continue
+		}
+		span := text[start : end+1]
+		spans = append(spans, span)
+		if _, ok := parseSpan(span, repair); ok {
+			start = end
+		}
+	}
+	return spans
+}
+
+// matchingBracket returns the index of the bracket closing the one at start, or -1 if the
+// brackets are not balanced. Single-quoted strings are skipped too when repair is enabled.
+func matchingBracket(text string, start int, repair bool) int {
+	stack := []byte{}
+	var quote byte
+	escaped := false
+	for i := start; i < len(text); i++ {
+		c := text[i]
+		if quote != 0 {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == quote:
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			quote = c
+		case '\'':
+			if repair {
+				quote = c
+			}
+		case '{':
+			stack = append(stack, '}')
+		case '[':
+			stack = append(stack, ']')
+		case '}', ']':
+			if len(stack) == 0 || stack[len(stack)-1] != c {
+				return -1
+			}
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// repairJSON fixes common defects of JSON written by models: trailing commas before a closing
+// bracket, single-quoted strings, and newlines, carriage returns and tabs left unescaped in strings.
+func repairJSON(text string) string {
+	var out strings.Builder
+	var quote byte
+	escaped := false
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if quote != 0 {
+			switch {
+			case escaped:
+				escaped = false
+				out.WriteByte(c)
+			case c == '\\' && i+1 < len(text) && text[i+1] == '\'':
+				// A single quote needs no escape in a double-quoted string
+				out.WriteByte('\'')
+				i++
+			case c == '\\':
+				escaped = true
+				out.WriteByte(c)
+			case c == quote:
+				quote = 0
+				out.WriteByte('"')
+			case c == '"':
+				out.WriteString(`\"`)
+			case c == '\n':
+				out.WriteString(`\n`)
+			case c == '\r':
+				out.WriteString(`\r`)
+			case c == '\t':
+				out.WriteString(`\t`)
+			default:
+				out.WriteByte(c)
+			}
+			continue
+		}
+
+		switch c {
+		case '"', '\'':
+			quote = c
+			out.WriteByte('"')
+		case ',':
+			// Drop the comma if only whitespace separates it from a closing bracket
+			next := i + 1
+			for next < len(text) && strings.IndexByte(" \t\r\n", text[next]) >= 0 {
+				next++
+			}
+			if next < len(text) && (text[next] == '}' || text[next] == ']') {
+				continue
+			}
+			out.WriteByte(c)
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String()
+}
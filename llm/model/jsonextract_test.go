@@ -0,0 +1,106 @@
+package model
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name         string
+		text         string
+		repair       bool
+		expectJSON   string
+		expectRepair bool
+		expectError  bool
+	}{
+		{
+			name:       "Plain object",
+			text:       ` {"a": 1} `,
+			expectJSON: `{"a": 1}`,
+		},
+		{
+			name:       "Nested object in prose",
+			text:       `Here is the answer: {"author": {"name": "Ada", "ids": [1, 2]}, "year": 1843}. Hope it helps!`,
+			expectJSON: `{"author": {"name": "Ada", "ids": [1, 2]}, "year": 1843}`,
+		},
+		{
+			name:       "Top-level array",
+			text:       `The items are [{"id": 1}, {"id": 2}]`,
+			expectJSON: `[{"id": 1}, {"id": 2}]`,
+		},
+		{
+			name:       "Markdown code fence",
+			text:       "Sure:\n```json\n{\"a\": \"b}\"}\n```\n",
+			expectJSON: `{"a": "b}"}`,
+		},
+		{
+			name:       "Largest value wins",
+			text:       `Example: {"x": 1}. Answer: {"x": 1, "y": [2, 3]}`,
+			expectJSON: `{"x": 1, "y": [2, 3]}`,
+		},
+		{
+			name:        "Trailing comma without repair",
+			text:        `{"a": 1, "b": [1, 2,],}`,
+			expectError: true,
+		},
+		{
+			name:         "Trailing comma with repair",
+			text:         `{"a": 1, "b": [1, 2,],}`,
+			repair:       true,
+			expectJSON:   `{"a": 1, "b": [1, 2]}`,
+			expectRepair: true,
+		},
+		{
+			name:         "Single quotes with repair",
+			text:         `{'title': 'It\'s "here"'}`,
+			repair:       true,
+			expectJSON:   `{"title": "It's \"here\""}`,
+			expectRepair: true,
+		},
+		{
+			name:         "Unescaped newline with repair",
+			text:         "{\"abstract\": \"line one\nline two\"}",
+			repair:       true,
+			expectJSON:   `{"abstract": "line one\nline two"}`,
+			expectRepair: true,
+		},
+		{
+			name:        "No JSON at all",
+			text:        `I cannot answer that.`,
+			repair:      true,
+			expectError: true,
+		},
+		{
+			name:        "Mismatched brackets",
+			text:        `{"a": [1, 2}`,
+			expectError: true,
+		},
+		{
+			name:       "Truncated answer keeps the largest complete value",
+			text:       `{"a": {"b": 1}, "c": `,
+			expectJSON: `{"b": 1}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			extracted, err := ExtractJSON(tc.text, tc.repair)
+			if tc.expectError {
+				if !errors.Is(err, ErrInvalidJSON) {
+					t.Fatalf("expected ErrInvalidJSON, got %v (%+v)", err, extracted)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if extracted.JSON != tc.expectJSON {
+				t.Errorf("expected %s, got %s", tc.expectJSON, extracted.JSON)
+			}
+			if extracted.Repaired != tc.expectRepair {
+				t.Errorf("expected repaired %v, got %v", tc.expectRepair, extracted.Repaired)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/open-and-sustainable/alembica/definitions"
@@ -9,56 +10,44 @@ import (
 // QueryService defines the interface for querying various LLM providers.
 type QueryService interface {
 	// QueryLLM sends a list of prompts to a specified LLM provider and returns responses.
+	// Cancelling ctx aborts in-flight provider calls and rate-limit waits.
 	//
 	// Parameters:
-	//   - prompts: A list of string prompts to be processed by the LLM.
+	//   - ctx: The context controlling cancellation and deadlines of the query.
+	//   - prompts: The prompts of a sequence, in order, to be processed by the LLM.
 	//   - llm: The model configuration containing provider details and parameters.
 	//
 	// Returns:
-	//   - A list of responses from the model.
-	//   - An error if the request fails.
-	QueryLLM(prompts []string, llm definitions.Model) ([]string, error)
+	//   - The answers of the model, one per prompt answered, each holding the samples requested by llm.
+	//   - An error if the request fails. When prompt k of n fails, the answers to the
+	//     preceding k-1 prompts are returned together with the error.
+	QueryLLM(ctx context.Context, prompts []definitions.Prompt, llm definitions.Model) ([]Answer, error)
 }
 
 // DefaultQueryService implements the QueryService interface and routes queries to the appropriate LLM provider.
 type DefaultQueryService struct{}
 
-// QueryLLM determines the correct function to use based on the LLM provider and queries the model.
+// QueryLLM determines the correct function to use based on the LLM provider, built in or
+// registered with RegisterProvider, and queries the model.
 //
 // Parameters:
-//   - prompts: A list of string prompts to be processed by the LLM.
+//   - ctx: The context controlling cancellation and deadlines of the query.
+//   - prompts: The prompts of a sequence, in order, to be processed by the LLM.
 //   - llm: The model configuration containing provider details and parameters.
 //
 // Returns:
-//   - A list of responses from the model.
-//   - An error if the provider is not supported or the query fails.
-func (dqs DefaultQueryService) QueryLLM(prompts []string, llm definitions.Model) ([]string, error) {
-	var queryFunc func([]string, definitions.Model) ([]string, error)
+//   - The answers of the model, one per prompt answered, each holding the samples requested by llm.
+//   - An error if the provider is not supported or does not accept a generation parameter of
+//     the model, the query fails, or ctx is done.
+//     Answers collected before a failing prompt are returned together with the error.
+func (dqs DefaultQueryService) QueryLLM(ctx context.Context, prompts []definitions.Prompt, llm definitions.Model) ([]Answer, error) {
+	if err := ValidateParameters(llm); err != nil {
+		return nil, err
+	}
 
-	switch llm.Provider {
-	case "OpenAI":
-		queryFunc = queryOpenAI
-	case "GoogleAI":
-		queryFunc = queryGoogleAI
-	case "Cohere":
-		queryFunc = queryCohere
-	case "Anthropic":
-		queryFunc = queryAnthropic
-	case "DeepSeek":
-		queryFunc = queryDeepSeek
-	case "Perplexity":
-		queryFunc = queryPerplexity
-	case "AWSBedrock":
-		queryFunc = queryAWSBedrock
-	case "AzureAI":
-		queryFunc = queryAzureAI
-	case "VertexAI":
-		queryFunc = queryVertexAI
-	case "SelfHosted":
-		queryFunc = querySelfHosted
-	default:
+	provider, known := lookupProvider(llm.Provider)
+	if !known {
 		return nil, fmt.Errorf("unsupported LLM provider: %s", llm.Provider)
 	}
-
-	return queryFunc(prompts, llm)
+	return provider.query(ctx, prompts, llm)
 }
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"context"
 	"errors"
 	"testing"
 
@@ -9,12 +10,12 @@ import (
 
 // MockQueryService implements QueryService for testing
 type MockQueryService struct {
-	MockResponse []string
+	MockResponse []Answer
 	MockError    error
 }
 
 // Implements QueryLLM method for mocking
-func (mqs MockQueryService) QueryLLM(prompts []string, llm definitions.Model) ([]string, error) {
+func (mqs MockQueryService) QueryLLM(ctx context.Context, prompts []definitions.Prompt, llm definitions.Model) ([]Answer, error) {
 	if mqs.MockError != nil {
 		return nil, mqs.MockError
 	}
@@ -25,24 +26,24 @@ func TestQueryLLM_MockService(t *testing.T) {
 	// Define test cases
 	tests := []struct {
 		name        string
-		prompts     []string
+		prompts     []definitions.Prompt
 		llm         definitions.Model
-		mockResp    []string
+		mockResp    []Answer
 		mockErr     error
-		expectResp  []string
+		expectResp  []Answer
 		expectError bool
 	}{
 		{
 			name:       "Successful query",
-			prompts:    []string{"Hello, AI!"},
+			prompts:    []definitions.Prompt{{PromptContent: "Hello, AI!", SequenceID: "1", SequenceNumber: 1}},
 			llm:        definitions.Model{Provider: "MockProvider"},
-			mockResp:   []string{"Hello, human!"},
+			mockResp:   []Answer{{Responses: []string{"Hello, human!"}}},
 			mockErr:    nil,
-			expectResp: []string{"Hello, human!"},
+			expectResp: []Answer{{Responses: []string{"Hello, human!"}}},
 		},
 		{
 			name:        "LLM returns an error",
-			prompts:     []string{"Error test"},
+			prompts:     []definitions.Prompt{{PromptContent: "Error test", SequenceID: "1", SequenceNumber: 1}},
 			llm:         definitions.Model{Provider: "MockProvider"},
 			mockResp:    nil,
 			mockErr:     errors.New("API failure"),
@@ -60,7 +61,7 @@ func TestQueryLLM_MockService(t *testing.T) {
 			}
 
 			// Call QueryLLM
-			resp, err := mockService.QueryLLM(tc.prompts, tc.llm)
+			resp, err := mockService.QueryLLM(context.Background(), tc.prompts, tc.llm)
 
 			// Check if error expectation matches
 			if tc.expectError && err == nil {
@@ -74,8 +75,8 @@ func TestQueryLLM_MockService(t *testing.T) {
 				t.Errorf("%s: expected response length %d, got %d", tc.name, len(tc.expectResp), len(resp))
 			} else {
 				for i := range resp {
-					if resp[i] != tc.expectResp[i] {
-						t.Errorf("%s: expected response %q, got %q", tc.name, tc.expectResp[i], resp[i])
+					if resp[i].Responses[0] != tc.expectResp[i].Responses[0] {
+						t.Errorf("%s: expected response %q, got %q", tc.name, tc.expectResp[i].Responses[0], resp[i].Responses[0])
 					}
 				}
 			}
@@ -0,0 +1,27 @@
+package model
+
+import (
+	"cmp"
+	"context"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+	"github.com/openai/openai-go/v3/option"
+)
+
+// mistralBaseURL is the endpoint of La Plateforme, Mistral AI's API.
+const mistralBaseURL = "https://api.mistral.ai/v1"
+
+func queryMistral(ctx context.Context, prompts []definitions.Prompt, llm definitions.Model) ([]Answer, error) {
+	// Mistral's chat completions API is OpenAI-compatible
+	client := newOpenAIClient(llm,
+		option.WithAPIKey(llm.APIKey),
+		option.WithBaseURL(cmp.Or(llm.BaseURL, mistralBaseURL)),
+	)
+
+	return runSequence(ctx, prompts, llm, openAICompleter(client, llm, openAIEndpoint{
+		provider:        "Mistral",
+		nativeSamples:   true,
+		legacyMaxTokens: true,
+		seedParam:       "random_seed",
+	}))
+}
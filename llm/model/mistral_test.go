@@ -0,0 +1,55 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+)
+
+func TestQueryMistral(t *testing.T) {
+	var body map[string]json.RawMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		writeChatCompletion(w, `{"city": "Paris"}`, 2)
+	}))
+	defer server.Close()
+
+	seed := int64(7)
+	llm := definitions.Model{Provider: "Mistral", Model: "mistral-small-latest", BaseURL: server.URL, Temperature: 0.2,
+		MaxOutputTokens: 512, Seed: &seed, Samples: 2}
+	prompts := []definitions.Prompt{{
+		PromptContent:  "Capital of France?",
+		SequenceID:     "1",
+		SequenceNumber: 1,
+		ResponseSchema: json.RawMessage(`{"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}`),
+	}}
+
+	answers, err := queryMistral(context.Background(), prompts, llm)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(answers) != 1 || len(answers[0].Responses) != 2 {
+		t.Errorf("expected 2 samples from one request, got %+v", answers)
+	}
+
+	expected := map[string]string{"random_seed": "7", "max_tokens": "512", "n": "2"}
+	for key, value := range expected {
+		if string(body[key]) != value {
+			t.Errorf("expected %s %s, got %s", key, value, body[key])
+		}
+	}
+	for _, key := range []string{"seed", "max_completion_tokens"} {
+		if _, exists := body[key]; exists {
+			t.Errorf("unexpected %s in request: %s", key, body[key])
+		}
+	}
+	if !strings.Contains(string(body["response_format"]), `"json_schema"`) || !strings.Contains(string(body["response_format"]), `"city"`) {
+		t.Errorf("expected the json_schema response format, got %s", body["response_format"])
+	}
+}
@@ -0,0 +1,177 @@
+package model
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"strings"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+	"github.com/open-and-sustainable/alembica/utils/logger"
+)
+
+// ollamaDefaultBaseURL is the address of a local Ollama server.
+const ollamaDefaultBaseURL = "http://localhost:11434"
+
+// ollamaMessage is a message of the Ollama chat API.
+type ollamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ollamaChatResponse is the part of a non-streamed /api/chat response that is used.
+type ollamaChatResponse struct {
+	Message         ollamaMessage `json:"message"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+}
+
+// ollamaError is an error response of an Ollama server.
+type ollamaError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *ollamaError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Message)
+}
+
+// HTTPStatusCode returns the HTTP status of the response, so that ClassifyError can use it.
+func (e *ollamaError) HTTPStatusCode() int {
+	return e.StatusCode
+}
+
+func queryOllama(ctx context.Context, prompts []definitions.Prompt, llm definitions.Model) ([]Answer, error) {
+	endpoint := strings.TrimSuffix(cmp.Or(llm.BaseURL, ollamaDefaultBaseURL), "/") + "/api/chat"
+	options := ollamaOptions(llm)
+
+	return runSequence(ctx, prompts, llm, func(ctx context.Context, request completion) ([]string, error) {
+		var response ollamaChatResponse
+		if err := postOllama(ctx, endpoint, llm.APIKey, ollamaRequest(llm, request, options), &response); err != nil {
+			return nil, fmt.Errorf("no response from Ollama: %w", err)
+		}
+		logger.Info(fmt.Sprintf("Ollama answered with %d prompt and %d output tokens (done: %s)", response.PromptEvalCount, response.EvalCount, response.DoneReason))
+
+		if response.Message.Content == "" {
+			return nil, nil
+		}
+		return []string{response.Message.Content}, nil
+	})
+}
+
+// ollamaOptions maps the temperature and generation parameters of a model to Ollama model
+// options. The options set on the model are applied last and override the others.
+func ollamaOptions(llm definitions.Model) map[string]any {
+	options := map[string]any{"temperature": llm.Temperature}
+	if llm.MaxOutputTokens > 0 {
+		options["num_predict"] = llm.MaxOutputTokens
+	}
+	if llm.TopP != nil {
+		options[paramTopP] = *llm.TopP
+	}
+	if llm.TopK > 0 {
+		options[paramTopK] = llm.TopK
+	}
+	if llm.Seed != nil {
+		options[paramSeed] = *llm.Seed
+	}
+	if len(llm.Stop) > 0 {
+		options[paramStop] = llm.Stop
+	}
+	if llm.PresencePenalty != nil {
+		options[paramPresencePenalty] = *llm.PresencePenalty
+	}
+	if llm.FrequencyPenalty != nil {
+		options[paramFrequencyPenalty] = *llm.FrequencyPenalty
+	}
+	if llm.NumCtx > 0 {
+		options[paramNumCtx] = llm.NumCtx
+	}
+	maps.Copy(options, llm.Options)
+	return options
+}
+
+// ollamaRequest builds the body of a chat request. JSON answers are requested with the json
+// format, and answers with a response schema by passing the schema as the format, which
+// Ollama enforces while decoding. The extra_params of the model are merged last.
+func ollamaRequest(llm definitions.Model, request completion, options map[string]any) map[string]any {
+	messages := []ollamaMessage{}
+	if request.system != "" {
+		messages = append(messages, ollamaMessage{Role: "system", Content: request.system})
+	}
+	for _, m := range request.messages {
+		messages = append(messages, ollamaMessage{Role: m.role, Content: m.content})
+	}
+
+	body := map[string]any{
+		"model":    llm.Model,
+		"messages": messages,
+		"stream":   false,
+		"options":  options,
+	}
+	switch request.format {
+	case formatJSON:
+		body["format"] = "json"
+	case formatJSONSchema:
+		body["format"] = request.responseSchema
+	}
+	if llm.KeepAlive != "" {
+		body[paramKeepAlive] = llm.KeepAlive
+	}
+	maps.Copy(body, llm.ExtraParams)
+	return body
+}
+
+// postOllama sends a request to an Ollama endpoint and decodes the response.
+//
+// Parameters:
+//   - ctx: The context controlling cancellation and deadlines of the request.
+//   - url: The endpoint.
+//   - key: A bearer token for servers behind an authenticating proxy, or empty.
+//   - body: The request body, encoded as JSON.
+//   - out: Where to decode the response.
+//
+// Returns:
+//   - An *ollamaError if the server answers with an error status, or another error if the
+//     request fails or the response cannot be decoded.
+func postOllama(ctx context.Context, url string, key string, body any, out any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		message := strings.TrimSpace(string(data))
+		var failure struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &failure) == nil && failure.Error != "" {
+			message = failure.Error
+		}
+		return &ollamaError{StatusCode: resp.StatusCode, Message: message}
+	}
+	return json.Unmarshal(data, out)
+}
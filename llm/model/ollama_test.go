@@ -0,0 +1,176 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+)
+
+// newOllamaServer starts an Ollama stand-in that records the body of each chat request and
+// answers with the given content.
+func newOllamaServer(t *testing.T, content string, bodies *[]map[string]json.RawMessage) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			http.NotFound(w, r)
+			return
+		}
+		var body map[string]json.RawMessage
+		json.NewDecoder(r.Body).Decode(&body)
+		*bodies = append(*bodies, body)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"model":             "llama3.1",
+			"message":           map[string]any{"role": "assistant", "content": content},
+			"done":              true,
+			"done_reason":       "stop",
+			"prompt_eval_count": 12,
+			"eval_count":        5,
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestQueryOllamaFormat(t *testing.T) {
+	schema := json.RawMessage(`{"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}`)
+
+	tests := []struct {
+		name         string
+		prompt       definitions.Prompt
+		answer       string
+		expectFormat string
+	}{
+		{name: "JSON by default", answer: `{"city": "Paris"}`, expectFormat: `"json"`},
+		{name: "Schema as format", prompt: definitions.Prompt{ResponseSchema: schema}, answer: `{"city": "Paris"}`, expectFormat: string(schema)},
+		{name: "Text sends no format", prompt: definitions.Prompt{ResponseFormat: formatText}, answer: "Paris.", expectFormat: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var bodies []map[string]json.RawMessage
+			server := newOllamaServer(t, tc.answer, &bodies)
+			llm := definitions.Model{Provider: "Ollama", Model: "llama3.1", BaseURL: server.URL + "/"}
+
+			prompt := tc.prompt
+			prompt.PromptContent, prompt.SequenceID, prompt.SequenceNumber = "Capital of France?", "1", 1
+			answers, err := queryOllama(context.Background(), []definitions.Prompt{prompt}, llm)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if answers[0].Responses[0] != tc.answer {
+				t.Errorf("expected %q, got %q", tc.answer, answers[0].Responses[0])
+			}
+
+			format := bodies[0]["format"]
+			if tc.expectFormat == "" && format != nil {
+				t.Errorf("unexpected format %s", format)
+			}
+			if tc.expectFormat != "" && !equalJSON(format, json.RawMessage(tc.expectFormat)) {
+				t.Errorf("expected format %s, got %s", tc.expectFormat, format)
+			}
+			if string(bodies[0]["stream"]) != "false" {
+				t.Errorf("expected a non-streamed request, got stream %s", bodies[0]["stream"])
+			}
+		})
+	}
+}
+
+func TestQueryOllamaOptions(t *testing.T) {
+	var bodies []map[string]json.RawMessage
+	server := newOllamaServer(t, `{"ok": true}`, &bodies)
+	topP, seed := 0.9, int64(7)
+	llm := definitions.Model{
+		Provider:        "Ollama",
+		Model:           "llama3.1",
+		BaseURL:         server.URL,
+		Temperature:     0.2,
+		SystemPrompt:    "You extract data.",
+		MaxOutputTokens: 256,
+		TopP:            &topP,
+		Seed:            &seed,
+		Stop:            []string{"END"},
+		KeepAlive:       "10m",
+		NumCtx:          8192,
+		Options:         map[string]any{"num_ctx": 16384, "mirostat": 2},
+		Samples:         2,
+	}
+
+	answers, err := queryOllama(context.Background(), promptsOf("First"), llm)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Ollama answers once per request, so each sample is a request
+	if len(bodies) != 2 || len(answers[0].Responses) != 2 {
+		t.Fatalf("expected 2 requests and 2 samples, got %d and %+v", len(bodies), answers)
+	}
+
+	var options map[string]any
+	json.Unmarshal(bodies[0]["options"], &options)
+	expected := map[string]any{
+		"temperature": 0.2,
+		"num_predict": 256.0,
+		"top_p":       0.9,
+		"seed":        7.0,
+		"stop":        []any{"END"},
+		"num_ctx":     16384.0, // The options override the num_ctx setting
+		"mirostat":    2.0,
+	}
+	if !equalValues(options, expected) {
+		t.Errorf("expected options %v, got %v", expected, options)
+	}
+	if string(bodies[0]["keep_alive"]) != `"10m"` {
+		t.Errorf("expected keep_alive 10m, got %s", bodies[0]["keep_alive"])
+	}
+
+	var messages []ollamaMessage
+	json.Unmarshal(bodies[0]["messages"], &messages)
+	if len(messages) != 2 || messages[0] != (ollamaMessage{Role: "system", Content: "You extract data."}) || messages[1].Content != "First" {
+		t.Errorf("expected the system prompt then the prompt, got %+v", messages)
+	}
+}
+
+func TestQueryOllamaErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		category ErrorCategory
+	}{
+		{name: "Missing model", status: http.StatusNotFound, body: `{"error": "model \"llama9\" not found, try pulling it first"}`, category: ErrorUnknown},
+		{name: "Overloaded server", status: http.StatusServiceUnavailable, body: `{"error": "server busy"}`, category: ErrorProvider},
+		{name: "Throttled proxy", status: http.StatusTooManyRequests, body: `too many requests`, category: ErrorRateLimit},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+
+			llm := definitions.Model{Provider: "Ollama", Model: "llama9", BaseURL: server.URL}
+			_, err := queryOllama(context.Background(), promptsOf("First"), llm)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if category, _ := ClassifyError(err); category != tc.category {
+				t.Errorf("expected the %s category, got %s (%v)", tc.category, category, err)
+			}
+		})
+	}
+}
+
+// equalJSON reports whether two JSON documents encode the same value.
+func equalJSON(left json.RawMessage, right json.RawMessage) bool {
+	var l, r any
+	if json.Unmarshal(left, &l) != nil || json.Unmarshal(right, &r) != nil {
+		return false
+	}
+	return equalValues(l, r)
+}
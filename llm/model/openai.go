@@ -11,61 +11,198 @@ import (
 	"github.com/openai/openai-go/v3/option"
 )
 
-func queryOpenAI(prompts []string, llm definitions.Model) ([]string, error) {
-	answers := []string{}
-
+func queryOpenAI(ctx context.Context, prompts []definitions.Prompt, llm definitions.Model) ([]Answer, error) {
 	// Create a new OpenAI client
-	client := openai.NewClient(
+	client := newOpenAIClient(llm,
 		option.WithAPIKey(llm.APIKey),
 	)
 
-	// Initialize conversation history
-	messages := []openai.ChatCompletionMessageParamUnion{}
+	return runSequence(ctx, prompts, llm, openAICompleter(client, llm, openAIEndpoint{provider: "OpenAI", nativeSamples: true}))
+}
 
-	for i, prompt := range prompts {
-		// Append user message to conversation history
-		messages = append(messages, openai.UserMessage(prompt))
+// newOpenAIClient creates a client for OpenAI or an OpenAI-compatible endpoint. When the model
+// has a retry policy the SDK's own retries are disabled so that every attempt is recorded.
+//
+// Parameters:
+//   - llm: The model configuration.
+//   - options: The options configuring the endpoint and credentials.
+//
+// Returns:
+//   - The client.
+func newOpenAIClient(llm definitions.Model, options ...option.RequestOption) openai.Client {
+	if llm.Retry != nil {
+		options = append(options, option.WithMaxRetries(0))
+	}
+	return openai.NewClient(options...)
+}
 
-		// Make API call
-		resp, err := client.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
-			Model:    openai.ChatModel(llm.Model),
-			Messages: messages,
-			ResponseFormat: openai.ChatCompletionNewParamsResponseFormatUnion{
-				OfJSONObject: &openai.ResponseFormatJSONObjectParam{},
-			},
+// openAIEndpoint describes how an OpenAI-compatible provider is called.
+type openAIEndpoint struct {
+	provider      string // Provider name used in logs and errors.
+	nativeSamples bool   // Whether the endpoint honours the n parameter.
+	// legacyMaxTokens sends the output token limit as max_tokens, for endpoints that do not
+	// know max_completion_tokens.
+	legacyMaxTokens bool
+	// seedParam sends the seed under another name, for endpoints that do not call it seed.
+	seedParam string
+	// structuredOutput requests answers conforming to a response schema; when nil the
+	// json_schema response format is used.
+	structuredOutput func(params *openai.ChatCompletionNewParams, schema map[string]any)
+	// jsonMode requests JSON answers for prompts without a response schema; when nil the
+	// json_object response format is used.
+	jsonMode func(params *openai.ChatCompletionNewParams)
+}
+
+// openAICompleter returns a completer for the chat completions API, shared by OpenAI and the
+// OpenAI-compatible providers.
+//
+// Parameters:
+//   - client: The client configured for the provider endpoint.
+//   - llm: The model configuration.
+//   - endpoint: How the provider is called.
+//
+// Returns:
+//   - The completer.
+func openAICompleter(client openai.Client, llm definitions.Model, endpoint openAIEndpoint) completer {
+	provider := endpoint.provider
+	return func(ctx context.Context, request completion) ([]string, error) {
+		params := openai.ChatCompletionNewParams{
+			Model:       openai.ChatModel(llm.Model),
+			Messages:    openAIMessages(request.system, request.messages),
 			Temperature: openai.Float(llm.Temperature),
-		})
+		}
+		if request.format == formatJSON {
+			if endpoint.jsonMode != nil {
+				endpoint.jsonMode(&params)
+			} else {
+				params.ResponseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{
+					OfJSONObject: &openai.ResponseFormatJSONObjectParam{},
+				}
+			}
+		}
+		if endpoint.nativeSamples && request.samples > 1 {
+			params.N = openai.Int(int64(request.samples))
+		}
+		if request.responseSchema != nil {
+			schema, err := schemaObject(request.responseSchema)
+			if err != nil {
+				return nil, err
+			}
+			if endpoint.structuredOutput != nil {
+				endpoint.structuredOutput(&params, schema)
+			} else {
+				jsonSchemaFormat(&params, schema)
+			}
+		}
+		openAIParameters(&params, llm, endpoint)
 
+		// Make API call
+		resp, err := client.Chat.Completions.New(ctx, params)
 		if err != nil {
 			logger.Error(fmt.Sprintf("Completion error: %v", err))
-			return nil, fmt.Errorf("no response from OpenAI: %v", err)
+			return nil, fmt.Errorf("no response from %s: %w", provider, err)
 		}
 
 		// Log full response JSON
 		respJSON, err := json.MarshalIndent(resp, "", "  ")
 		if err != nil {
-			logger.Error("Failed to marshal response:", err)
+			logger.Error(fmt.Sprintf("Failed to marshal response: %v", err))
 			return nil, err
 		}
-		logger.Info(fmt.Sprintf("Full OpenAI response: %s", string(respJSON)))
+		logger.Info(fmt.Sprintf("Full %s response: %s", provider, string(respJSON)))
 
-		// Extract response text
-		if len(resp.Choices) == 0 || resp.Choices[0].Message.Content == "" {
-			logger.Error("No content found in response")
-			return nil, fmt.Errorf("no content in response")
+		return openAIChoices(resp, provider)
+	}
+}
+
+// openAIParameters sets the generation parameters of the model on a chat completion request.
+// top_k, a renamed seed and extra_params are merged into the request body, after any extension
+// set for the response schema.
+func openAIParameters(params *openai.ChatCompletionNewParams, llm definitions.Model, endpoint openAIEndpoint) {
+	if llm.MaxOutputTokens > 0 {
+		if endpoint.legacyMaxTokens {
+			params.MaxTokens = openai.Int(int64(llm.MaxOutputTokens))
+		} else {
+			params.MaxCompletionTokens = openai.Int(int64(llm.MaxOutputTokens))
+		}
+	}
+	if llm.TopP != nil {
+		params.TopP = openai.Float(*llm.TopP)
+	}
+	if llm.Seed != nil && endpoint.seedParam == "" {
+		params.Seed = openai.Int(*llm.Seed)
+	}
+	if len(llm.Stop) > 0 {
+		params.Stop = openai.ChatCompletionNewParamsStopUnion{OfStringArray: llm.Stop}
+	}
+	if llm.PresencePenalty != nil {
+		params.PresencePenalty = openai.Float(*llm.PresencePenalty)
+	}
+	if llm.FrequencyPenalty != nil {
+		params.FrequencyPenalty = openai.Float(*llm.FrequencyPenalty)
+	}
+	fields := extraFields(params.ExtraFields(), llm, true)
+	if llm.Seed != nil && endpoint.seedParam != "" {
+		if _, exists := llm.ExtraParams[endpoint.seedParam]; !exists {
+			fields[endpoint.seedParam] = *llm.Seed
 		}
+	}
+	if len(fields) > 0 {
+		params.SetExtraFields(fields)
+	}
+}
 
-		answer := resp.Choices[0].Message.Content
-		answers = append(answers, answer)
+// jsonSchemaFormat asks for answers conforming to the schema with the json_schema response format.
+func jsonSchemaFormat(params *openai.ChatCompletionNewParams, schema map[string]any) {
+	params.ResponseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{
+		OfJSONSchema: &openai.ResponseFormatJSONSchemaParam{
+			JSONSchema: openai.ResponseFormatJSONSchemaJSONSchemaParam{
+				Name:   "response",
+				Schema: schema,
+			},
+		},
+	}
+}
 
-		// Append model response to conversation history
-		messages = append(messages, openai.AssistantMessage(answer))
+// openAIMessages converts the conversation to chat completion messages, preceded by the
+// system prompt if there is one. Reasoning models treat system messages as developer messages.
+func openAIMessages(system string, messages []message) []openai.ChatCompletionMessageParamUnion {
+	params := make([]openai.ChatCompletionMessageParamUnion, 0, len(messages)+1)
+	if system != "" {
+		params = append(params, openai.SystemMessage(system))
+	}
+	for _, m := range messages {
+		if m.role == roleAssistant {
+			params = append(params, openai.AssistantMessage(m.content))
+		} else {
+			params = append(params, openai.UserMessage(m.content))
+		}
+	}
+	return params
+}
 
-		// Call wait for all prompts except the last one
-		if i < len(prompts)-1 {
-			Wait(prompt, llm)
+// openAIChoices extracts the text of the choices of a chat completion.
+// Choices stopped by the content filter are dropped; if all of them were, the response is an error.
+func openAIChoices(resp *openai.ChatCompletion, provider string) ([]string, error) {
+	candidates := []string{}
+	filtered := false
+	for _, choice := range resp.Choices {
+		if choice.FinishReason == "content_filter" {
+			filtered = true
+			continue
+		}
+		if choice.Message.Content != "" {
+			candidates = append(candidates, choice.Message.Content)
 		}
 	}
 
-	return answers, nil
+	if len(candidates) == 0 {
+		if filtered {
+			logger.Error("Response blocked by content filter")
+			return nil, fmt.Errorf("%w (%s)", ErrContentFiltered, provider)
+		}
+		logger.Error("No content found in response")
+		return nil, fmt.Errorf("no content in response")
+	}
+	return candidates, nil
 }
@@ -0,0 +1,106 @@
+package model
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+)
+
+// Names of the generation parameters of a model, as they appear in the input.
+const (
+	paramMaxOutputTokens  = "max_output_tokens"
+	paramTopP             = "top_p"
+	paramTopK             = "top_k"
+	paramSeed             = "seed"
+	paramStop             = "stop"
+	paramPresencePenalty  = "presence_penalty"
+	paramFrequencyPenalty = "frequency_penalty"
+	paramExtraParams      = "extra_params"
+	paramKeepAlive        = "keep_alive"
+	paramNumCtx           = "num_ctx"
+	paramOptions          = "options"
+)
+
+// ValidateParameters checks that the provider of a model accepts every generation parameter
+// set on it. Parameters specific to a model family, such as top_k on AWS Bedrock, can still be
+// passed through extra_params where the provider accepts them.
+//
+// Parameters:
+//   - llm: The model configuration.
+//
+// Returns:
+//   - An error wrapping ErrUnsupportedParameter that names the parameters the provider does not
+//     accept, or nil. Unknown providers are left to QueryLLM to report.
+func ValidateParameters(llm definitions.Model) error {
+	provider, known := lookupProvider(llm.Provider)
+	if !known {
+		return nil
+	}
+	unsupported := []string{}
+	for _, name := range setParameters(llm) {
+		if !slices.Contains(provider.Parameters, name) {
+			unsupported = append(unsupported, name)
+		}
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("%w: %s does not support %s", ErrUnsupportedParameter, llm.Provider, strings.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+// setParameters returns the names of the generation parameters set on a model.
+func setParameters(llm definitions.Model) []string {
+	names := []string{}
+	if llm.MaxOutputTokens > 0 {
+		names = append(names, paramMaxOutputTokens)
+	}
+	if llm.TopP != nil {
+		names = append(names, paramTopP)
+	}
+	if llm.TopK > 0 {
+		names = append(names, paramTopK)
+	}
+	if llm.Seed != nil {
+		names = append(names, paramSeed)
+	}
+	if len(llm.Stop) > 0 {
+		names = append(names, paramStop)
+	}
+	if llm.PresencePenalty != nil {
+		names = append(names, paramPresencePenalty)
+	}
+	if llm.FrequencyPenalty != nil {
+		names = append(names, paramFrequencyPenalty)
+	}
+	if len(llm.ExtraParams) > 0 {
+		names = append(names, paramExtraParams)
+	}
+	if llm.KeepAlive != "" {
+		names = append(names, paramKeepAlive)
+	}
+	if llm.NumCtx > 0 {
+		names = append(names, paramNumCtx)
+	}
+	if len(llm.Options) > 0 {
+		names = append(names, paramOptions)
+	}
+	return names
+}
+
+// extraFields returns the fields to merge into a request body: those already set on the
+// request, then top_k if the endpoint only takes it as an extension, then the extra_params of
+// the model, which override the others.
+func extraFields(existing map[string]any, llm definitions.Model, topK bool) map[string]any {
+	fields := maps.Clone(existing)
+	if fields == nil {
+		fields = map[string]any{}
+	}
+	if topK && llm.TopK > 0 {
+		fields[paramTopK] = llm.TopK
+	}
+	maps.Copy(fields, llm.ExtraParams)
+	return fields
+}
@@ -0,0 +1,125 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+)
+
+func TestValidateParameters(t *testing.T) {
+	topP := 0.9
+	seed := int64(42)
+	penalty := 0.5
+
+	tests := []struct {
+		name        string
+		llm         definitions.Model
+		expectError string
+	}{
+		{
+			name: "No parameters",
+			llm:  definitions.Model{Provider: "DeepSeek", Model: "deepseek-chat"},
+		},
+		{
+			name: "All parameters on SelfHosted",
+			llm: definitions.Model{Provider: "SelfHosted", Model: "llama3", MaxOutputTokens: 512, TopP: &topP, TopK: 40, Seed: &seed,
+				Stop: []string{"END"}, PresencePenalty: &penalty, FrequencyPenalty: &penalty, ExtraParams: map[string]any{"min_p": 0.05}},
+		},
+		{
+			name:        "Seed and penalties on Anthropic",
+			llm:         definitions.Model{Provider: "Anthropic", Model: "claude-sonnet-4-5", MaxOutputTokens: 16000, Seed: &seed, PresencePenalty: &penalty},
+			expectError: "Anthropic does not support seed, presence_penalty",
+		},
+		{
+			name:        "Top-k on OpenAI",
+			llm:         definitions.Model{Provider: "OpenAI", Model: "gpt-4o", TopK: 40},
+			expectError: "OpenAI does not support top_k",
+		},
+		{
+			name:        "Top-k on Mistral",
+			llm:         definitions.Model{Provider: "Mistral", Model: "mistral-small-latest", Seed: &seed, TopK: 40},
+			expectError: "Mistral does not support top_k",
+		},
+		{
+			name:        "Extra parameters on DeepSeek",
+			llm:         definitions.Model{Provider: "DeepSeek", Model: "deepseek-chat", ExtraParams: map[string]any{"logprobs": true}},
+			expectError: "DeepSeek does not support extra_params",
+		},
+		{
+			name:        "Ollama settings on SelfHosted",
+			llm:         definitions.Model{Provider: "SelfHosted", Model: "llama3", KeepAlive: "10m", NumCtx: 8192},
+			expectError: "SelfHosted does not support keep_alive, num_ctx",
+		},
+		{
+			name: "Unknown provider",
+			llm:  definitions.Model{Provider: "Unknown", Model: "x", TopK: 40},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateParameters(tc.llm)
+			if tc.expectError == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrUnsupportedParameter) || !strings.Contains(err.Error(), tc.expectError) {
+				t.Errorf("expected %q, got %v", tc.expectError, err)
+			}
+		})
+	}
+}
+
+func TestQuerySelfHostedParameters(t *testing.T) {
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		writeChatCompletion(w, `{"city": "Paris"}`, 1)
+	}))
+	defer server.Close()
+
+	topP := 0.9
+	seed := int64(42)
+	llm := definitions.Model{
+		Provider: "SelfHosted", Model: "local-model", BaseURL: server.URL,
+		MaxOutputTokens: 512, TopP: &topP, TopK: 40, Seed: &seed, Stop: []string{"END"},
+		ExtraParams: map[string]any{"min_p": 0.05, "top_k": 20},
+	}
+	prompts := []definitions.Prompt{{PromptContent: "Capital of France?", SequenceID: "1", SequenceNumber: 1,
+		ResponseSchema: json.RawMessage(`{"type": "object"}`)}}
+	if _, err := querySelfHosted(context.Background(), prompts, llm); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]any{
+		"max_tokens": 512.0,
+		"top_p":      0.9,
+		"seed":       42.0,
+		"min_p":      0.05,
+		"top_k":      20.0, // extra_params override the typed parameters
+	}
+	for key, value := range expected {
+		if body[key] != value {
+			t.Errorf("expected %s = %v, got %v", key, value, body[key])
+		}
+	}
+	if stop, ok := body["stop"].([]any); !ok || len(stop) != 1 || stop[0] != "END" {
+		t.Errorf("expected stop [END], got %v", body["stop"])
+	}
+	// Extra parameters are merged with the guided decoding extension, not replacing it
+	if _, exists := body["guided_json"]; !exists {
+		t.Errorf("expected guided_json to be kept, got %v", body)
+	}
+	if _, exists := body["max_completion_tokens"]; exists {
+		t.Errorf("unexpected max_completion_tokens for a self-hosted endpoint")
+	}
+}
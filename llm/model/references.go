@@ -0,0 +1,101 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// stepReference matches a {{stepN}} or {{stepN.path}} reference to the answer of the prompt with
+// sequence number N. Paths are made of .field and [index] steps, such as outcomes[0].name.
+var stepReference = regexp.MustCompile(`\{\{\s*step(\d+)((?:\.[^.\[\]{}\s]+|\[\d+\])*)\s*\}\}`)
+
+// pathStep matches one step of a reference path.
+var pathStep = regexp.MustCompile(`\.([^.\[\]{}\s]+)|\[(\d+)\]`)
+
+// resolveReferences replaces the references to earlier answers in the content of a prompt.
+// A reference without a path inserts the answer as stored; with a path it inserts the value
+// found in the JSON answer, strings as they are and other values as JSON. Other placeholders
+// are left untouched.
+//
+// Parameters:
+//   - content: The content of the prompt.
+//   - answers: The first response to each earlier prompt of the sequence, by sequence number.
+//
+// Returns:
+//   - The content with the references replaced.
+//   - An error wrapping ErrUnresolvedReference if a reference names a prompt not answered yet,
+//     a path that does not exist, or a path into an answer that is not JSON.
+func resolveReferences(content string, answers map[int]string) (string, error) {
+	var unresolved error
+	resolved := stepReference.ReplaceAllStringFunc(content, func(match string) string {
+		groups := stepReference.FindStringSubmatch(match)
+		value, err := referencedValue(groups[1], groups[2], answers)
+		if err != nil {
+			if unresolved == nil {
+				unresolved = fmt.Errorf("%w: %s: %v", ErrUnresolvedReference, match, err)
+			}
+			return match
+		}
+		return value
+	})
+	return resolved, unresolved
+}
+
+// referencedValue returns the text inserted for a reference to the given step and path.
+func referencedValue(step string, path string, answers map[int]string) (string, error) {
+	number, _ := strconv.Atoi(step)
+	answer, exists := answers[number]
+	if !exists {
+		return "", fmt.Errorf("no earlier answer with sequence number %d", number)
+	}
+	if path == "" {
+		return answer, nil
+	}
+
+	value, err := lookupPath(answer, path)
+	if err != nil {
+		return "", err
+	}
+	if text, ok := value.(string); ok {
+		return text, nil
+	}
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+	return strings.TrimSpace(buffer.String()), nil
+}
+
+// lookupPath decodes a JSON answer, keeping numbers as json.Number, and returns the value found
+// at a path of .field and [index] steps; an empty path returns the whole value.
+func lookupPath(answer string, path string) (any, error) {
+	decoder := json.NewDecoder(strings.NewReader(answer))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("the answer is not JSON")
+	}
+	for _, match := range pathStep.FindAllStringSubmatch(path, -1) {
+		switch current := value.(type) {
+		case map[string]any:
+			field, ok := current[match[1]]
+			if match[1] == "" || !ok {
+				return nil, fmt.Errorf("no field %q", match[0])
+			}
+			value = field
+		case []any:
+			index, err := strconv.Atoi(match[2])
+			if match[2] == "" || err != nil || index >= len(current) {
+				return nil, fmt.Errorf("no element %q", match[0])
+			}
+			value = current[index]
+		default:
+			return nil, fmt.Errorf("cannot apply %q to a scalar", match[0])
+		}
+	}
+	return value, nil
+}
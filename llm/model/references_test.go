@@ -0,0 +1,48 @@
+package model
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestResolveReferences(t *testing.T) {
+	answers := map[int]string{
+		1: `{"outcomes": ["mortality", "growth"], "study": {"sites": [{"name": "Bonaire", "depth": 12}]}, "note": "<none>"}`,
+		2: "The paper studies coral reefs.",
+	}
+
+	tests := []struct {
+		name        string
+		content     string
+		expect      string
+		expectError bool
+	}{
+		{name: "Array as JSON", content: "For each outcome in {{step1.outcomes}} extract the effect size.", expect: `For each outcome in ["mortality","growth"] extract the effect size.`},
+		{name: "Nested path", content: "Depth at {{ step1.study.sites[0].name }}: {{step1.study.sites[0].depth}}", expect: "Depth at Bonaire: 12"},
+		{name: "String kept as is", content: "Note: {{step1.note}}", expect: "Note: <none>"},
+		{name: "Whole text answer", content: "Given the summary \"{{step2}}\", list the sites.", expect: "Given the summary \"The paper studies coral reefs.\", list the sites."},
+		{name: "Other placeholders untouched", content: "Use {{abstract}} and {step1}", expect: "Use {{abstract}} and {step1}"},
+		{name: "Later step", content: "{{step3.x}}", expectError: true},
+		{name: "Missing field", content: "{{step1.authors}}", expectError: true},
+		{name: "Index out of range", content: "{{step1.outcomes[2]}}", expectError: true},
+		{name: "Path into text answer", content: "{{step2.sites}}", expectError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			resolved, err := resolveReferences(tc.content, answers)
+			if tc.expectError {
+				if !errors.Is(err, ErrUnresolvedReference) {
+					t.Errorf("expected ErrUnresolvedReference, got %v (%q)", err, resolved)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resolved != tc.expect {
+				t.Errorf("expected %q, got %q", tc.expect, resolved)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+	"github.com/open-and-sustainable/alembica/llm/check"
+	"github.com/open-and-sustainable/alembica/llm/tokens"
+	"github.com/open-and-sustainable/alembica/pricing"
+)
+
+// queryFunc sends the prompts of a sequence to a provider, with the same contract as
+// QueryService.QueryLLM.
+type queryFunc func(ctx context.Context, prompts []definitions.Prompt, llm definitions.Model) ([]Answer, error)
+
+// Message is one turn of the conversation sent to a registered provider.
+type Message struct {
+	Role    string // "user" or "assistant"
+	Content string
+}
+
+// Completion asks a registered provider for the next assistant turn of a conversation.
+type Completion struct {
+	System         string          // Instructions for the system role, or empty.
+	Messages       []Message       // Conversation so far, ending with the user prompt to answer.
+	Samples        int             // Number of candidates wanted; returning fewer leads to further calls.
+	Format         string          // Response format: "text", "json" or "json_schema".
+	ResponseSchema json.RawMessage // JSON Schema the answer must conform to, or nil.
+}
+
+// CompleteFunc sends one completion request to a registered provider and returns the candidate
+// texts of the next assistant turn.
+type CompleteFunc func(ctx context.Context, request Completion, llm definitions.Model) ([]string, error)
+
+// Provider describes an LLM provider: how it is queried and, for providers registered with
+// RegisterProvider, how its tokens are counted, its models checked and its inputs priced.
+// Built-in providers count tokens, check models and price inputs in the tokens, check and
+// pricing packages.
+type Provider struct {
+	// Complete answers a single completion request. The sequences of a registered provider are
+	// run by alembica like those of the built-in ones: conversation history, rate limits,
+	// retries, samples, JSON extraction and validation, re-asks, references, conditions and
+	// examples are handled around it. Required.
+	Complete CompleteFunc
+	// CountTokens returns the number of tokens in a prompt for a model, used for rate limits
+	// and cost estimates; nil if the provider cannot count tokens.
+	CountTokens func(ctx context.Context, prompt string, llm definitions.Model) int
+	// CheckModel returns the model to use for a requested model name, or an empty string if
+	// the model is unsupported; nil accepts any non-empty model name.
+	CheckModel func(prompt string, model string, key string) string
+	// InputPrices are the dollar prices per million input tokens, by model.
+	InputPrices map[string]float64
+	// Parameters are the generation parameters the provider accepts, named as in the input,
+	// such as "max_output_tokens", "top_p", "top_k", "seed", "stop", "presence_penalty",
+	// "frequency_penalty" and "extra_params".
+	Parameters []string
+
+	query queryFunc // Runs a whole sequence; built from Complete for registered providers
+}
+
+// providers maps the name of each provider to its description, starting with the built-in ones.
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]Provider{
+		"OpenAI":     {query: queryOpenAI, Parameters: []string{paramMaxOutputTokens, paramTopP, paramSeed, paramStop, paramPresencePenalty, paramFrequencyPenalty, paramExtraParams}},
+		"AzureAI":    {query: queryAzureAI, Parameters: []string{paramMaxOutputTokens, paramTopP, paramSeed, paramStop, paramPresencePenalty, paramFrequencyPenalty, paramExtraParams}},
+		"Perplexity": {query: queryPerplexity, Parameters: []string{paramMaxOutputTokens, paramTopP, paramTopK, paramPresencePenalty, paramFrequencyPenalty, paramExtraParams}},
+		"SelfHosted": {query: querySelfHosted, Parameters: []string{paramMaxOutputTokens, paramTopP, paramTopK, paramSeed, paramStop, paramPresencePenalty, paramFrequencyPenalty, paramExtraParams}},
+		"GoogleAI":   {query: queryGoogleAI, Parameters: []string{paramMaxOutputTokens, paramTopP, paramTopK, paramSeed, paramStop, paramPresencePenalty, paramFrequencyPenalty, paramExtraParams}},
+		"VertexAI":   {query: queryVertexAI, Parameters: []string{paramMaxOutputTokens, paramTopP, paramTopK, paramSeed, paramStop, paramPresencePenalty, paramFrequencyPenalty, paramExtraParams}},
+		"Cohere":     {query: queryCohere, Parameters: []string{paramMaxOutputTokens, paramTopP, paramTopK, paramSeed, paramStop, paramPresencePenalty, paramFrequencyPenalty, paramExtraParams}},
+		"Anthropic":  {query: queryAnthropic, Parameters: []string{paramMaxOutputTokens, paramTopP, paramTopK, paramStop, paramExtraParams}},
+		"AWSBedrock": {query: queryAWSBedrock, Parameters: []string{paramMaxOutputTokens, paramTopP, paramStop, paramExtraParams}},
+		"DeepSeek":   {query: queryDeepSeek, Parameters: []string{paramMaxOutputTokens, paramTopP, paramStop, paramPresencePenalty, paramFrequencyPenalty}},
+		"Mistral":    {query: queryMistral, Parameters: []string{paramMaxOutputTokens, paramTopP, paramSeed, paramStop, paramPresencePenalty, paramFrequencyPenalty, paramExtraParams}},
+		"Ollama":     {query: queryOllama, Parameters: []string{paramMaxOutputTokens, paramTopP, paramTopK, paramSeed, paramStop, paramPresencePenalty, paramFrequencyPenalty, paramExtraParams, paramKeepAlive, paramNumCtx, paramOptions}},
+	}
+)
+
+// RegisterProvider adds a provider, such as an in-house gateway, without changes to alembica.
+// Once registered, models naming it are queried through DefaultQueryService, its generation
+// parameters are checked by ValidateParameters, its tokens are counted by tokens.RealTokenCounter,
+// its models are checked by check.GetModel, its inputs are priced by pricing.ComputeCosts, and
+// the v2 input schema accepts it. Providers should be registered before extractions start,
+// typically in an init function.
+//
+// Parameters:
+//   - name: The name models use in their provider field.
+//   - provider: The description of the provider; Complete is required.
+//
+// Returns:
+//   - An error if the name is empty or already taken, or Complete is missing.
+func RegisterProvider(name string, provider Provider) error {
+	if name == "" {
+		return errors.New("provider name is empty")
+	}
+	if provider.Complete == nil {
+		return fmt.Errorf("provider %s has no completion function", name)
+	}
+	provider.query = registeredQuery(provider.Complete)
+
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	if _, exists := providers[name]; exists {
+		return fmt.Errorf("provider %s is already registered", name)
+	}
+	provider.Parameters = slices.Clone(provider.Parameters)
+	providers[name] = provider
+
+	tokens.RegisterCounter(name, provider.CountTokens)
+	check.RegisterModelCheck(name, provider.CheckModel)
+	pricing.RegisterPrices(name, provider.InputPrices)
+	definitions.RegisterProvider(name)
+	return nil
+}
+
+// registeredQuery runs the sequences of a registered provider with its completion function.
+func registeredQuery(complete CompleteFunc) queryFunc {
+	return func(ctx context.Context, prompts []definitions.Prompt, llm definitions.Model) ([]Answer, error) {
+		return runSequence(ctx, prompts, llm, func(ctx context.Context, request completion) ([]string, error) {
+			messages := make([]Message, len(request.messages))
+			for i, m := range request.messages {
+				messages[i] = Message{Role: m.role, Content: m.content}
+			}
+			return complete(ctx, Completion{
+				System:         request.system,
+				Messages:       messages,
+				Samples:        request.samples,
+				Format:         request.format,
+				ResponseSchema: request.responseSchema,
+			}, llm)
+		})
+	}
+}
+
+// Providers returns the names of the built-in and registered providers.
+//
+// Returns:
+//   - The names, sorted.
+func Providers() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupProvider returns the description of a provider and whether it is known.
+func lookupProvider(name string) (Provider, bool) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	provider, known := providers[name]
+	return provider, known
+}
@@ -0,0 +1,128 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+	"github.com/open-and-sustainable/alembica/llm/check"
+	"github.com/open-and-sustainable/alembica/llm/tokens"
+	"github.com/open-and-sustainable/alembica/pricing"
+	"github.com/open-and-sustainable/alembica/validation"
+)
+
+func TestRegisterProvider(t *testing.T) {
+	var requests []Completion
+	gateway := Provider{
+		Complete: func(ctx context.Context, request Completion, llm definitions.Model) ([]string, error) {
+			requests = append(requests, request)
+			return []string{fmt.Sprintf("Sure: {\"turn\": %d}", len(request.Messages)/2+1)}, nil
+		},
+		CountTokens: func(ctx context.Context, prompt string, llm definitions.Model) int {
+			if llm.BaseURL != "" && llm.BaseURL != "https://gateway.internal" {
+				return 0
+			}
+			return 40
+		},
+		InputPrices: map[string]float64{"gw-large": 2.5},
+		Parameters:  []string{"max_output_tokens"},
+	}
+	if err := RegisterProvider("InHouseGateway", gateway); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Sequences are run around the registered completion function like those of built-in
+	// providers: samples, JSON extraction, references and history are handled by alembica
+	llm := definitions.Model{Provider: "InHouseGateway", Model: "gw-large", MaxOutputTokens: 100, Samples: 2, SystemPrompt: "Be brief."}
+	answers, err := DefaultQueryService{}.QueryLLM(context.Background(), promptsOf("Hello", "Turn was {{step1.turn}}"), llm)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(answers) != 2 || !slices.Equal(answers[0].Responses, []string{`{"turn": 1}`, `{"turn": 1}`}) || answers[1].Responses[0] != `{"turn": 2}` {
+		t.Errorf("expected the extracted JSON of two samples per prompt, got %+v", answers)
+	}
+	if len(requests) != 4 || requests[0].Samples != 2 || requests[1].Samples != 1 || requests[0].System != "Be brief." || requests[0].Format != "json" {
+		t.Fatalf("expected repeated calls for the missing samples, got %+v", requests)
+	}
+	expected := []Message{{Role: "user", Content: "Hello"}, {Role: "assistant", Content: `{"turn": 1}`}, {Role: "user", Content: "Turn was 1"}}
+	if !slices.Equal(requests[2].Messages, expected) {
+		t.Errorf("expected the history with the resolved reference, got %+v", requests[2].Messages)
+	}
+
+	// Generation parameters are checked against those it accepts
+	topP := 0.9
+	llm.TopP = &topP
+	if err := ValidateParameters(llm); !errors.Is(err, ErrUnsupportedParameter) {
+		t.Errorf("expected top_p to be rejected, got %v", err)
+	}
+
+	if count := (tokens.RealTokenCounter{}).GetNumTokensFromPrompt("Hello", "InHouseGateway", "gw-large", ""); count != 40 {
+		t.Errorf("expected the registered token counter, got %d", count)
+	}
+	// The counter sees the whole model configuration, such as its endpoint
+	gatewayModel := definitions.Model{Provider: "InHouseGateway", Model: "gw-large", BaseURL: "https://gateway.internal"}
+	if count := (tokens.RealTokenCounter{}).CountModelTokens(context.Background(), "Hello", gatewayModel); count != 40 {
+		t.Errorf("expected the registered token counter to count at the model endpoint, got %d", count)
+	}
+	gatewayModel.BaseURL = "https://elsewhere.internal"
+	if count := (tokens.RealTokenCounter{}).CountModelTokens(context.Background(), "Hello", gatewayModel); count != 0 {
+		t.Errorf("expected the registered token counter to receive the model endpoint, got %d", count)
+	}
+	if selected := check.GetModel("Hello", "InHouseGateway", "gw-large", ""); selected != "gw-large" {
+		t.Errorf("expected any model to be accepted without a check, got %q", selected)
+	}
+	if !slices.Contains(Providers(), "InHouseGateway") || !slices.Contains(Providers(), "OpenAI") {
+		t.Errorf("expected built-in and registered providers, got %v", Providers())
+	}
+
+	// The v2 schema accepts the provider, and costs use its prices
+	input := `{
+		"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+		"models": [{"provider": "InHouseGateway", "model": "gw-large", "temperature": 0}],
+		"prompts": [{"promptContent": "Hello", "sequenceId": "1", "sequenceNumber": 1}]
+	}`
+	if err := validation.ValidateInput(input, "v2"); err != nil {
+		t.Errorf("expected the v2 schema to accept the provider, got %v", err)
+	}
+	costsJSON, err := pricing.ComputeCosts(input, "v2")
+	if err != nil {
+		t.Fatalf("unexpected error computing costs: %v", err)
+	}
+	var costs definitions.CostOutput
+	if err := json.Unmarshal([]byte(costsJSON), &costs); err != nil {
+		t.Fatalf("invalid cost JSON: %v", err)
+	}
+	if len(costs.Costs) == 0 || costs.Costs[0].Cost != 0.0001 {
+		t.Errorf("expected 40 tokens at $2.50 per million, got %+v", costs.Costs)
+	}
+}
+
+func TestRegisterProviderRejectsInvalidProviders(t *testing.T) {
+	complete := func(ctx context.Context, request Completion, llm definitions.Model) ([]string, error) {
+		return nil, nil
+	}
+	tests := []struct {
+		name     string
+		provider string
+		complete CompleteFunc
+	}{
+		{name: "Empty name", provider: "", complete: complete},
+		{name: "Built-in name", provider: "OpenAI", complete: complete},
+		{name: "No completion function", provider: "NoQuery"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := RegisterProvider(tc.provider, Provider{Complete: tc.complete}); err == nil {
+				t.Errorf("expected an error registering %q", tc.provider)
+			}
+		})
+	}
+	if _, known := lookupProvider("NoQuery"); known {
+		t.Errorf("expected the invalid provider not to be registered")
+	}
+}
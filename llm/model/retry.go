@@ -0,0 +1,135 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+	"github.com/open-and-sustainable/alembica/utils/logger"
+)
+
+// Defaults applied to the unset fields of a retry policy.
+const (
+	defaultMaxAttempts = 3
+	defaultBaseDelay   = time.Second
+	defaultMaxDelay    = time.Minute
+)
+
+// defaultRetryOn lists the error categories retried when a policy does not name any.
+var defaultRetryOn = []ErrorCategory{ErrorRateLimit, ErrorTimeout, ErrorProvider, ErrorNetwork}
+
+// RetryError is returned when a provider call still fails after the retry policy of the model
+// gave up, either because the attempts are exhausted or because the error is not retryable.
+type RetryError struct {
+	// Attempts records every call made for the prompt that failed.
+	Attempts []definitions.Attempt
+	// Err is the error of the last attempt.
+	Err error
+}
+
+func (e *RetryError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// completeWithRetry sends a completion request, retrying transient failures according to the
+// retry policy of the model. Without a policy the request is sent once and no attempts are recorded.
+// Providers disable the retries of their SDK when the model has a retry policy, so that every
+// attempt is made, and recorded, here.
+//
+// Parameters:
+//   - ctx: The context controlling cancellation, including of the delays between attempts.
+//   - llm: The model configuration holding the retry policy.
+//   - complete: The provider call.
+//   - request: The completion request.
+//
+// Returns:
+//   - The candidates of the successful attempt.
+//   - The attempts made, with the error and delay of each failed one.
+//   - The error of the last attempt if none succeeded.
+func completeWithRetry(ctx context.Context, llm definitions.Model, complete completer, request completion) ([]string, []definitions.Attempt, error) {
+	policy := llm.Retry
+	if policy == nil {
+		candidates, err := complete(ctx, request)
+		return candidates, nil, err
+	}
+
+	maxAttempts := policy.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	attempts := []definitions.Attempt{}
+	for attempt := 1; ; attempt++ {
+		candidates, err := complete(ctx, request)
+		if err == nil {
+			attempts = append(attempts, definitions.Attempt{Attempt: attempt})
+			return candidates, attempts, nil
+		}
+
+		record := definitions.Attempt{Attempt: attempt, Error: DescribeError(err)}
+		if attempt >= maxAttempts || ctx.Err() != nil || !retryable(policy, err) {
+			attempts = append(attempts, record)
+			return nil, attempts, err
+		}
+
+		delay := backoff(policy, attempt)
+		record.DelayMs = delay.Milliseconds()
+		attempts = append(attempts, record)
+		logger.Info(fmt.Sprintf("Attempt %d of %d on %s failed: %v. Retrying in %v.", attempt, maxAttempts, LimiterKey(llm), err, delay))
+
+		if err := sleep(ctx, delay); err != nil {
+			return nil, attempts, err
+		}
+		// The retry is a request of its own for the rate limiter
+		if err := Wait(ctx, request.messages[len(request.messages)-1].content, llm); err != nil {
+			return nil, attempts, err
+		}
+	}
+}
+
+// retryable reports whether the policy retries the category of err.
+func retryable(policy *definitions.RetryPolicy, err error) bool {
+	category, _ := ClassifyError(err)
+	if len(policy.RetryOn) == 0 {
+		return slices.Contains(defaultRetryOn, category)
+	}
+	return slices.Contains(policy.RetryOn, string(category))
+}
+
+// backoff returns the delay before the attempt following the given one: the base delay doubled
+// after each failed attempt, spread by the jitter fraction and capped at the maximum delay.
+func backoff(policy *definitions.RetryPolicy, attempt int) time.Duration {
+	base := defaultBaseDelay
+	if policy.BaseDelayMs > 0 {
+		base = time.Duration(policy.BaseDelayMs) * time.Millisecond
+	}
+	maxDelay := defaultMaxDelay
+	if policy.MaxDelayMs > 0 {
+		maxDelay = time.Duration(policy.MaxDelayMs) * time.Millisecond
+	}
+
+	delay := float64(base) * float64(uint64(1)<<min(attempt-1, 32))
+	if policy.Jitter > 0 {
+		delay *= 1 + policy.Jitter*(2*rand.Float64()-1)
+	}
+	return min(time.Duration(delay), maxDelay)
+}
+
+// sleep waits for the given duration unless the context is done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
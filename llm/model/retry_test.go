@@ -0,0 +1,167 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+	"github.com/openai/openai-go/v3"
+)
+
+// failingCompleter fails with the given errors, one per call, then answers.
+func failingCompleter(calls *int, failures ...error) completer {
+	return func(ctx context.Context, request completion) ([]string, error) {
+		*calls++
+		if *calls <= len(failures) {
+			return nil, failures[*calls-1]
+		}
+		return []string{`{"ok": true}`}, nil
+	}
+}
+
+// openAIStatusError builds an API error as returned by the OpenAI SDK.
+func openAIStatusError(status int) *openai.Error {
+	return &openai.Error{
+		StatusCode: status,
+		Request:    httptest.NewRequest(http.MethodPost, "http://localhost/v1/chat/completions", nil),
+		Response:   &http.Response{StatusCode: status},
+	}
+}
+
+func TestRunSequenceRetries(t *testing.T) {
+	unavailable := openAIStatusError(http.StatusServiceUnavailable)
+	unauthorized := openAIStatusError(http.StatusUnauthorized)
+	reset := errors.New("read: connection reset by peer")
+
+	tests := []struct {
+		name         string
+		retry        *definitions.RetryPolicy
+		failures     []error
+		expectCalls  int
+		expectError  bool
+		expectErrors []string
+	}{
+		{
+			name:         "Transient errors are retried",
+			retry:        &definitions.RetryPolicy{MaxAttempts: 3, BaseDelayMs: 1},
+			failures:     []error{unavailable, reset},
+			expectCalls:  3,
+			expectErrors: []string{"provider-5xx", "network", ""},
+		},
+		{
+			name:         "Attempts are exhausted",
+			retry:        &definitions.RetryPolicy{MaxAttempts: 2, BaseDelayMs: 1},
+			failures:     []error{unavailable, unavailable, unavailable},
+			expectCalls:  2,
+			expectError:  true,
+			expectErrors: []string{"provider-5xx", "provider-5xx"},
+		},
+		{
+			name:         "Non-retryable errors stop at once",
+			retry:        &definitions.RetryPolicy{MaxAttempts: 3, BaseDelayMs: 1},
+			failures:     []error{unauthorized},
+			expectCalls:  1,
+			expectError:  true,
+			expectErrors: []string{"auth"},
+		},
+		{
+			name:         "Retried categories are configurable",
+			retry:        &definitions.RetryPolicy{MaxAttempts: 3, BaseDelayMs: 1, RetryOn: []string{"network"}},
+			failures:     []error{unavailable},
+			expectCalls:  1,
+			expectError:  true,
+			expectErrors: []string{"provider-5xx"},
+		},
+		{
+			name:        "No policy means a single call",
+			failures:    []error{unavailable},
+			expectCalls: 1,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			llm := definitions.Model{Provider: "SelfHosted", Model: "retry-" + tc.name, Retry: tc.retry}
+			answers, err := runSequence(context.Background(), promptsOf("first"), llm, failingCompleter(&calls, tc.failures...))
+			if calls != tc.expectCalls {
+				t.Errorf("expected %d calls, got %d", tc.expectCalls, calls)
+			}
+			if (err != nil) != tc.expectError {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+
+			var attempts []definitions.Attempt
+			var retryErr *RetryError
+			switch {
+			case errors.As(err, &retryErr):
+				attempts = retryErr.Attempts
+			case err == nil:
+				attempts = answers[0].Attempts
+			}
+			if tc.retry == nil {
+				if retryErr != nil || (err == nil && len(attempts) > 0) {
+					t.Errorf("expected no attempts to be recorded without a policy, got %+v", attempts)
+				}
+				return
+			}
+
+			if len(attempts) != len(tc.expectErrors) {
+				t.Fatalf("expected %d attempts, got %+v", len(tc.expectErrors), attempts)
+			}
+			for i, attempt := range attempts {
+				category := ""
+				if attempt.Error != nil {
+					category = attempt.Error.Category
+				}
+				if attempt.Attempt != i+1 || category != tc.expectErrors[i] {
+					t.Errorf("attempt %d: unexpected record %+v", i+1, attempt)
+				}
+				// Only attempts followed by a retry wait
+				retried := i < len(attempts)-1
+				if retried != (attempt.DelayMs > 0) {
+					t.Errorf("attempt %d: unexpected delay %d", i+1, attempt.DelayMs)
+				}
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := &definitions.RetryPolicy{BaseDelayMs: 100, MaxDelayMs: 1000}
+	expected := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, want := range expected {
+		if got := backoff(policy, i+1); got != want*time.Millisecond {
+			t.Errorf("attempt %d: expected %v, got %v", i+1, want*time.Millisecond, got)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := backoff(policy, 2); got < 100*time.Millisecond || got > 300*time.Millisecond {
+			t.Fatalf("jittered delay %v outside of [100ms, 300ms]", got)
+		}
+	}
+}
+
+func TestRetryStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	complete := func(ctx context.Context, request completion) ([]string, error) {
+		calls++
+		cancel()
+		return nil, errors.New("connection refused")
+	}
+	llm := definitions.Model{Provider: "SelfHosted", Model: "retry-cancel", Retry: &definitions.RetryPolicy{MaxAttempts: 5, BaseDelayMs: 60000}}
+	if _, err := runSequence(ctx, promptsOf("first"), llm, complete); err == nil {
+		t.Fatal("expected an error")
+	}
+	if calls != 1 {
+		t.Errorf("expected no retry once the context is cancelled, got %d calls", calls)
+	}
+}
@@ -2,21 +2,30 @@ package model
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 
 	"github.com/open-and-sustainable/alembica/definitions"
-	"github.com/open-and-sustainable/alembica/utils/logger"
 	"github.com/openai/openai-go/v3"
 	"github.com/openai/openai-go/v3/option"
 )
 
-func querySelfHosted(prompts []string, llm definitions.Model) ([]string, error) {
-	answers := []string{}
+// Inference servers behind SelfHosted endpoints, named by the endpoint_type of the model. Each
+// constrains decoding to a response schema in its own way.
+const (
+	endpointVLLM     = "vllm"              // vLLM, the default
+	endpointLlamaCpp = "llama.cpp"         // The llama.cpp server
+	endpointTGI      = "tgi"               // Hugging Face Text Generation Inference
+	endpointOpenAI   = "openai-compatible" // Servers taking the json_schema response format, such as LM Studio
+)
 
+func querySelfHosted(ctx context.Context, prompts []definitions.Prompt, llm definitions.Model) ([]Answer, error) {
 	if llm.BaseURL == "" {
 		return nil, fmt.Errorf("missing base_url for SelfHosted provider")
 	}
+	endpoint, err := selfHostedEndpoint(llm.EndpointType)
+	if err != nil {
+		return nil, err
+	}
 
 	options := []option.RequestOption{
 		option.WithBaseURL(llm.BaseURL),
@@ -25,47 +34,74 @@ func querySelfHosted(prompts []string, llm definitions.Model) ([]string, error)
 		options = append(options, option.WithAPIKey(llm.APIKey))
 	}
 
-	client := openai.NewClient(options...)
-
-	// Initialize conversation history
-	messages := []openai.ChatCompletionMessageParamUnion{}
-
-	for i, prompt := range prompts {
-		messages = append(messages, openai.UserMessage(prompt))
+	client := newOpenAIClient(llm, options...)
 
-		resp, err := client.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
-			Model:    openai.ChatModel(llm.Model),
-			Messages: messages,
-			ResponseFormat: openai.ChatCompletionNewParamsResponseFormatUnion{
-				OfJSONObject: &openai.ResponseFormatJSONObjectParam{},
-			},
-			Temperature: openai.Float(llm.Temperature),
-		})
-		if err != nil {
-			logger.Error(fmt.Sprintf("Completion error: %v", err))
-			return nil, fmt.Errorf("no response from SelfHosted endpoint: %v", err)
-		}
+	return runSequence(ctx, prompts, llm, openAICompleter(client, llm, endpoint))
+}
 
-		respJSON, err := json.MarshalIndent(resp, "", "  ")
-		if err != nil {
-			logger.Error(fmt.Sprintf("Failed to marshal response: %v", err))
-			return nil, err
+// selfHostedEndpoint describes how a SelfHosted endpoint is called, with the guided decoding
+// of its inference server.
+//
+// Parameters:
+//   - endpointType: The endpoint_type of the model; empty for vLLM.
+//
+// Returns:
+//   - The endpoint description.
+//   - An error if the endpoint type is not known.
+func selfHostedEndpoint(endpointType string) (openAIEndpoint, error) {
+	endpoint := openAIEndpoint{provider: "SelfHosted", nativeSamples: true, legacyMaxTokens: true}
+	switch endpointType {
+	case "", endpointVLLM:
+		endpoint.structuredOutput = guidedJSON
+	case endpointLlamaCpp:
+		endpoint.structuredOutput = llamaCppSchema
+		endpoint.jsonMode = llamaCppJSONGrammar
+	case endpointTGI:
+		endpoint.structuredOutput = tgiJSONFormat
+		endpoint.jsonMode = func(params *openai.ChatCompletionNewParams) {
+			tgiJSONFormat(params, map[string]any{"type": "object"})
 		}
-		logger.Info(fmt.Sprintf("Full SelfHosted response: %s", string(respJSON)))
+	case endpointOpenAI:
+	default:
+		return endpoint, fmt.Errorf("unsupported endpoint_type %q for SelfHosted provider (use %s, %s, %s or %s)",
+			endpointType, endpointVLLM, endpointLlamaCpp, endpointTGI, endpointOpenAI)
+	}
+	return endpoint, nil
+}
 
-		if len(resp.Choices) == 0 || resp.Choices[0].Message.Content == "" {
-			logger.Error("No content found in response")
-			return nil, fmt.Errorf("no content in response")
-		}
+// guidedJSON constrains the answer to the schema with the guided_json extension of vLLM,
+// which replaces the response format.
+func guidedJSON(params *openai.ChatCompletionNewParams, schema map[string]any) {
+	params.ResponseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{}
+	params.SetExtraFields(map[string]any{"guided_json": schema})
+}
 
-		answer := resp.Choices[0].Message.Content
-		answers = append(answers, answer)
-		messages = append(messages, openai.AssistantMessage(answer))
+// llamaCppSchema constrains the answer to the schema with the json_schema field of the
+// llama.cpp server, which converts it to a grammar.
+func llamaCppSchema(params *openai.ChatCompletionNewParams, schema map[string]any) {
+	params.ResponseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{}
+	params.SetExtraFields(map[string]any{"json_schema": schema})
+}
 
-		if i < len(prompts)-1 {
-			Wait(prompt, llm)
-		}
-	}
+// llamaCppJSONGrammar constrains the answer to a JSON object with a GBNF grammar, which every
+// version of the llama.cpp server accepts.
+func llamaCppJSONGrammar(params *openai.ChatCompletionNewParams) {
+	params.SetExtraFields(map[string]any{"grammar": jsonGrammar})
+}
 
-	return answers, nil
+// tgiJSONFormat constrains the answer to the schema with the json response format of Text
+// Generation Inference, which carries the schema as its value instead of a json_schema object.
+func tgiJSONFormat(params *openai.ChatCompletionNewParams, schema map[string]any) {
+	params.ResponseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{}
+	params.SetExtraFields(map[string]any{"response_format": map[string]any{"type": "json", "value": schema}})
 }
+
+// jsonGrammar is a GBNF grammar of JSON objects, after the one shipped with llama.cpp.
+const jsonGrammar = `root   ::= object
+value  ::= object | array | string | number | ("true" | "false" | "null") ws
+object ::= "{" ws ( string ":" ws value ("," ws string ":" ws value)* )? "}" ws
+array  ::= "[" ws ( value ("," ws value)* )? "]" ws
+string ::= "\"" ( [^"\\\x7F\x00-\x1F] | "\\" (["\\bfnrt] | "u" [0-9a-fA-F]{4}) )* "\"" ws
+number ::= ("-"? ([0-9] | [1-9] [0-9]{0,15})) ("." [0-9]+)? ([eE] [-+]? [0-9] [1-9]{0,15})? ws
+ws     ::= | " " | "\n" [ \t]{0,20}
+`
@@ -0,0 +1,296 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+)
+
+// newChatServer starts an OpenAI-compatible stand-in that answers with the given
+// contents in order, repeated for the n choices requested, and fails every request
+// beyond them with HTTP 400.
+func newChatServer(t *testing.T, contents ...string) *httptest.Server {
+	t.Helper()
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { calls++ }()
+		w.Header().Set("Content-Type", "application/json")
+		if calls >= len(contents) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"message": "bad request", "type": "invalid_request_error"}}`))
+			return
+		}
+		var request struct {
+			N int `json:"n"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		writeChatCompletion(w, contents[calls], max(request.N, 1))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// writeChatCompletion writes a chat completion with n identical choices.
+func writeChatCompletion(w http.ResponseWriter, content string, n int) {
+	choices := []map[string]any{}
+	for i := 0; i < n; i++ {
+		choices = append(choices, map[string]any{
+			"index":         i,
+			"finish_reason": "stop",
+			"message":       map[string]any{"role": "assistant", "content": content},
+		})
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"id":      "chatcmpl-test",
+		"object":  "chat.completion",
+		"created": 0,
+		"model":   "local-model",
+		"choices": choices,
+	})
+}
+
+func TestQuerySelfHostedPartialAnswers(t *testing.T) {
+	server := newChatServer(t, `{"step": 1}`)
+	llm := definitions.Model{Provider: "SelfHosted", Model: "local-model", BaseURL: server.URL}
+
+	answers, err := querySelfHosted(context.Background(), promptsOf("first", "second", "third"), llm)
+	if err == nil {
+		t.Fatal("expected an error for the second prompt")
+	}
+	if len(answers) != 1 || answers[0].Responses[0] != `{"step": 1}` {
+		t.Errorf("expected the first answer to be kept, got %v", answers)
+	}
+}
+
+func TestQuerySelfHostedSamples(t *testing.T) {
+	server := newChatServer(t, `{"step": 1}`)
+	llm := definitions.Model{Provider: "SelfHosted", Model: "local-model", BaseURL: server.URL, Samples: 3}
+
+	// A single request must return all samples, since the stand-in fails any further call
+	answers, err := querySelfHosted(context.Background(), promptsOf("first"), llm)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(answers) != 1 || len(answers[0].Responses) != 3 {
+		t.Errorf("expected 3 samples from one request, got %+v", answers)
+	}
+}
+
+func TestResponseSchemaRequests(t *testing.T) {
+	schema := json.RawMessage(`{"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}`)
+
+	tests := []struct {
+		name       string
+		query      func(context.Context, []definitions.Prompt, definitions.Model) ([]Answer, error)
+		llm        definitions.Model
+		expectKeys []string
+		rejectKeys []string
+	}{
+		{
+			name:       "SelfHosted uses guided JSON",
+			query:      querySelfHosted,
+			llm:        definitions.Model{Provider: "SelfHosted", Model: "local-model"},
+			expectKeys: []string{"guided_json"},
+			rejectKeys: []string{"response_format"},
+		},
+		{
+			name:       "AzureAI uses the json_schema response format",
+			query:      queryAzureAI,
+			llm:        definitions.Model{Provider: "AzureAI", Model: "gpt-4o", APIVersion: "2024-10-21"},
+			expectKeys: []string{"response_format"},
+			rejectKeys: []string{"guided_json"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var body map[string]json.RawMessage
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewDecoder(r.Body).Decode(&body)
+				w.Header().Set("Content-Type", "application/json")
+				writeChatCompletion(w, `{"city": "Paris"}`, 1)
+			}))
+			defer server.Close()
+
+			llm := tc.llm
+			llm.BaseURL = server.URL
+			prompts := []definitions.Prompt{{PromptContent: "Capital of France?", SequenceID: "1", SequenceNumber: 1, ResponseSchema: schema}}
+			if _, err := tc.query(context.Background(), prompts, llm); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for _, key := range tc.expectKeys {
+				if !strings.Contains(string(body[key]), `"city"`) {
+					t.Errorf("expected %s to carry the schema, got %s", key, body[key])
+				}
+			}
+			for _, key := range tc.rejectKeys {
+				if _, exists := body[key]; exists {
+					t.Errorf("unexpected %s in request: %s", key, body[key])
+				}
+			}
+		})
+	}
+}
+
+func TestResponseFormatRequests(t *testing.T) {
+	tests := []struct {
+		name         string
+		format       string
+		answer       string
+		expectFormat string
+	}{
+		{name: "Text sends no response format", format: formatText, answer: "Paris is the capital.", expectFormat: ""},
+		{name: "JSON uses JSON mode", format: formatJSON, answer: `{"city": "Paris"}`, expectFormat: `{"type":"json_object"}`},
+		{name: "JSON by default", answer: `{"city": "Paris"}`, expectFormat: `{"type":"json_object"}`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var body map[string]json.RawMessage
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewDecoder(r.Body).Decode(&body)
+				w.Header().Set("Content-Type", "application/json")
+				writeChatCompletion(w, tc.answer, 1)
+			}))
+			defer server.Close()
+
+			llm := definitions.Model{Provider: "AzureAI", Model: "gpt-4o", APIVersion: "2024-10-21", BaseURL: server.URL}
+			prompts := []definitions.Prompt{{PromptContent: "Capital of France?", SequenceID: "1", SequenceNumber: 1, ResponseFormat: tc.format}}
+			answers, err := queryAzureAI(context.Background(), prompts, llm)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if answers[0].Responses[0] != tc.answer {
+				t.Errorf("expected %q, got %q", tc.answer, answers[0].Responses[0])
+			}
+			if string(body["response_format"]) != tc.expectFormat {
+				t.Errorf("expected response_format %s, got %s", tc.expectFormat, body["response_format"])
+			}
+		})
+	}
+}
+
+func TestQuerySelfHostedSystemPrompt(t *testing.T) {
+	type chatMessage struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	}
+	var conversations [][]chatMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Messages []chatMessage `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		conversations = append(conversations, request.Messages)
+		w.Header().Set("Content-Type", "application/json")
+		writeChatCompletion(w, `{"ok": true}`, 1)
+	}))
+	defer server.Close()
+
+	llm := definitions.Model{Provider: "SelfHosted", Model: "local-model", BaseURL: server.URL, SystemPrompt: "You extract data."}
+	if _, err := querySelfHosted(context.Background(), promptsOf("First", "Second"), llm); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(conversations) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(conversations))
+	}
+	// The system prompt opens every request, followed by the conversation
+	for i, messages := range conversations {
+		if len(messages) != 2*i+2 {
+			t.Fatalf("request %d: expected %d messages, got %+v", i+1, 2*i+2, messages)
+		}
+		if messages[0] != (chatMessage{Role: "system", Content: "You extract data."}) {
+			t.Errorf("request %d: expected the system prompt first, got %+v", i+1, messages[0])
+		}
+		if messages[1].Role != "user" || messages[1].Content != "First" {
+			t.Errorf("request %d: expected the first prompt after the system prompt, got %+v", i+1, messages[1])
+		}
+	}
+}
+
+func TestQuerySelfHostedSchemaMismatch(t *testing.T) {
+	server := newChatServer(t, `{"town": "Paris"}`)
+	llm := definitions.Model{Provider: "SelfHosted", Model: "local-model", BaseURL: server.URL}
+	prompts := []definitions.Prompt{{
+		PromptContent:  "Capital of France?",
+		SequenceID:     "1",
+		SequenceNumber: 1,
+		ResponseSchema: json.RawMessage(`{"type": "object", "required": ["city"]}`),
+	}}
+
+	_, err := querySelfHosted(context.Background(), prompts, llm)
+	if !errors.Is(err, ErrSchemaMismatch) {
+		t.Fatalf("expected a schema mismatch, got %v", err)
+	}
+	if category, _ := ClassifyError(err); category != ErrorInvalidJSON {
+		t.Errorf("expected the invalid-json category, got %s", category)
+	}
+}
+
+func TestQuerySelfHostedGuidedDecoding(t *testing.T) {
+	schema := json.RawMessage(`{"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}`)
+
+	tests := []struct {
+		name         string
+		endpointType string
+		schema       json.RawMessage
+		expectKey    string
+		expectValue  string // Expected in the value of expectKey
+		rejectKeys   []string
+	}{
+		{name: "vLLM by default", schema: schema, expectKey: "guided_json", expectValue: `"city"`, rejectKeys: []string{"response_format"}},
+		{name: "vLLM JSON mode", endpointType: "vllm", expectKey: "response_format", expectValue: `"json_object"`, rejectKeys: []string{"guided_json"}},
+		{name: "llama.cpp schema", endpointType: "llama.cpp", schema: schema, expectKey: "json_schema", expectValue: `"city"`, rejectKeys: []string{"response_format", "grammar"}},
+		{name: "llama.cpp JSON grammar", endpointType: "llama.cpp", expectKey: "grammar", expectValue: `root   ::= object`, rejectKeys: []string{"response_format", "json_schema"}},
+		{name: "TGI schema", endpointType: "tgi", schema: schema, expectKey: "response_format", expectValue: `{"type":"json","value":{"properties"`, rejectKeys: []string{"grammar"}},
+		{name: "TGI JSON mode", endpointType: "tgi", expectKey: "response_format", expectValue: `{"type":"json","value":{"type":"object"}}`, rejectKeys: []string{"grammar"}},
+		{name: "OpenAI-compatible schema", endpointType: "openai-compatible", schema: schema, expectKey: "response_format", expectValue: `"json_schema"`, rejectKeys: []string{"guided_json", "grammar"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var body map[string]json.RawMessage
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewDecoder(r.Body).Decode(&body)
+				w.Header().Set("Content-Type", "application/json")
+				writeChatCompletion(w, `{"city": "Paris"}`, 1)
+			}))
+			defer server.Close()
+
+			llm := definitions.Model{Provider: "SelfHosted", Model: "local-model", BaseURL: server.URL, EndpointType: tc.endpointType}
+			prompts := []definitions.Prompt{{PromptContent: "Capital of France?", SequenceID: "1", SequenceNumber: 1, ResponseSchema: tc.schema}}
+			if _, err := querySelfHosted(context.Background(), prompts, llm); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var value string
+			if err := json.Unmarshal(body[tc.expectKey], &value); err != nil {
+				value = string(body[tc.expectKey])
+			}
+			if !strings.Contains(value, tc.expectValue) {
+				t.Errorf("expected %s to contain %s, got %s", tc.expectKey, tc.expectValue, body[tc.expectKey])
+			}
+			for _, key := range tc.rejectKeys {
+				if _, exists := body[key]; exists {
+					t.Errorf("unexpected %s in request: %s", key, body[key])
+				}
+			}
+		})
+	}
+}
+
+func TestQuerySelfHostedUnknownEndpointType(t *testing.T) {
+	llm := definitions.Model{Provider: "SelfHosted", Model: "local-model", BaseURL: "http://localhost:8000/v1", EndpointType: "triton"}
+	_, err := querySelfHosted(context.Background(), promptsOf("First"), llm)
+	if err == nil || !strings.Contains(err.Error(), `unsupported endpoint_type "triton"`) {
+		t.Errorf("expected an unsupported endpoint type error, got %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+	"github.com/open-and-sustainable/alembica/utils/logger"
+)
+
+// Answer holds the completions a model returned for one prompt of a sequence.
+type Answer struct {
+	// Responses are the sampled completions; the first one continues the conversation.
+	Responses []string
+	// Attempts records the provider calls made for the prompt when the model has a retry policy.
+	Attempts []definitions.Attempt
+	// Repaired reports whether defective JSON was repaired in any of the responses.
+	Repaired bool
+	// Reasks counts the corrective follow-up turns sent for the prompt.
+	Reasks int
+	// Skipped reports that the prompt was not sent because of its condition or that of an earlier prompt.
+	Skipped bool
+}
+
+// Conversation roles of provider-neutral messages.
+const (
+	roleUser      = "user"
+	roleAssistant = "assistant"
+)
+
+// message is one turn of a conversation in provider-neutral form.
+type message struct {
+	role    string
+	content string
+}
+
+// completion asks a provider for the next assistant turn of a conversation.
+type completion struct {
+	system         string          // Instructions sent in the system or developer role, or empty.
+	messages       []message       // Conversation so far, ending with the user prompt to answer.
+	samples        int             // Number of candidates wanted; providers without native sampling return one.
+	format         string          // Response format: text, json or json_schema.
+	responseSchema json.RawMessage // JSON Schema the answer must conform to, or nil.
+}
+
+// completer sends one completion request to a provider and returns the candidate texts.
+type completer func(ctx context.Context, request completion) ([]string, error)
+
+// runSequence sends the prompts of a sequence one after the other, keeping the conversation
+// history so that each prompt is answered in the context of the previous ones, unless the model
+// is stateless. The system prompt of the model, if any, is sent with every request.
+// References to earlier answers in a prompt, such as {{step1.outcomes}}, are resolved before
+// it is sent, so answers can be chained with or without the history.
+//
+// The examples of a prompt are sent as earlier user and assistant turns right before it, and stay
+// in the conversation like the answers of the model.
+//
+// A prompt with a condition is sent only if the condition holds over the earlier answers.
+// Otherwise it is skipped, and depending on the condition the sequence goes on with the next
+// prompt, stops, or jumps to a later prompt, skipping those in between. Skipped prompts get an
+// answer without responses marked as skipped, and stay out of the conversation.
+//
+// When the model asks for several samples, the provider is asked for all of them at once and
+// called again until enough candidates are collected, so providers without native sampling
+// are handled by repeated calls. The first candidate of each prompt continues the conversation.
+// Each call is retried according to the retry policy of the model, if any. Answers to prompts
+// with the text response format are kept as returned. For the other prompts the JSON value of
+// every answer is extracted from its text, repairing it if the model allows, and answers to
+// prompts with a response schema are validated against it. Samples without valid JSON or not
+// conforming to the schema are dropped and requested again, keeping the valid samples of the
+// same call; a call without any valid answer fails. Up to llm.MaxReasks times per prompt, its
+// answer is then sent back in a corrective follow-up turn quoting the validation error, and only
+// the corrected answer is kept in the conversation.
+//
+// Parameters:
+//   - ctx: The context controlling cancellation and deadlines of the query.
+//   - prompts: The prompts of the sequence, in order.
+//   - llm: The model configuration.
+//   - complete: The provider call.
+//
+// Returns:
+//   - One answer per prompt answered or skipped.
+//   - An error if a call fails or a condition is not valid; the answers to the preceding prompts
+//     are returned with it.
+//     With a retry policy the error is a *RetryError recording the attempts for the failed prompt.
+func runSequence(ctx context.Context, prompts []definitions.Prompt, llm definitions.Model, complete completer) ([]Answer, error) {
+	answers := []Answer{}
+	history := []message{}
+	answered := make(map[int]string) // First response to each answered prompt, by sequence number
+	samples := max(llm.Samples, 1)
+	conditions, err := parseConditions(prompts)
+	if err != nil {
+		return answers, err
+	}
+
+	// Providers enforce the schema natively where they can; the answers are checked either way.
+	// Candidates failing validation are dropped as long as others of the same call pass, so that
+	// paid-for samples are kept and only the missing ones are requested again.
+	// The outcome of the last call is kept for the sequence loop.
+	var repaired bool
+	var rejected string // Text of an answer that failed validation
+	validated := func(ctx context.Context, request completion) ([]string, error) {
+		repaired, rejected = false, ""
+		candidates, err := complete(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		if request.format == formatText {
+			return candidates, nil
+		}
+		values := make([]string, 0, len(candidates))
+		var invalid error
+		for _, candidate := range candidates {
+			extracted, err := validateAnswer(candidate, request.responseSchema, llm.RepairJSON)
+			if err != nil {
+				if invalid == nil {
+					rejected, invalid = candidate, err
+				}
+				continue
+			}
+			values = append(values, extracted.JSON)
+			repaired = repaired || extracted.Repaired
+		}
+		if len(values) == 0 && invalid != nil {
+			return nil, invalid
+		}
+		if invalid != nil {
+			rejected = ""
+			logger.Info(fmt.Sprintf("Dropped %d of %d samples of %s (%v).", len(candidates)-len(values), len(candidates), LimiterKey(llm), invalid))
+		}
+		return values, nil
+	}
+
+	sent := false    // Whether a prompt was sent already
+	stopped := false // Whether a condition stopped the sequence
+	jumpTo := 0      // Sequence number of the prompt a condition jumps to
+	for i, prompt := range prompts {
+		if condition := conditions[i]; !stopped && prompt.SequenceNumber >= jumpTo && condition != nil && !condition.holds(answered) {
+			switch condition.otherwise {
+			case otherwiseStop:
+				stopped = true
+			case "":
+				jumpTo = condition.jumpTo
+			}
+			logger.Info(fmt.Sprintf("Condition of prompt %d of sequence %s does not hold. Skipping it.", prompt.SequenceNumber, prompt.SequenceID))
+			answers = append(answers, Answer{Responses: []string{}, Skipped: true})
+			continue
+		}
+		if stopped || prompt.SequenceNumber < jumpTo {
+			answers = append(answers, Answer{Responses: []string{}, Skipped: true})
+			continue
+		}
+
+		// Take a turn at the rate limiter for every prompt after the first one sent
+		if sent {
+			if err := Wait(ctx, prompt.PromptContent, llm); err != nil {
+				return answers, err
+			}
+		}
+		sent = true
+
+		format, err := responseFormat(prompt)
+		if err != nil {
+			return answers, err
+		}
+		content, err := resolveReferences(prompt.PromptContent, answered)
+		if err != nil {
+			return answers, err
+		}
+		if llm.Stateless {
+			history = []message{}
+		}
+		for _, example := range prompt.Examples {
+			history = append(history,
+				message{role: roleUser, content: example.Input},
+				message{role: roleAssistant, content: exampleAnswer(example.Output, format)})
+		}
+		history = append(history, message{role: roleUser, content: content})
+
+		responses := []string{}
+		var attempts []definitions.Attempt
+		promptRepaired := false
+		reasks := 0
+		var correction []message // Rejected answers and the follow-ups asking to fix them
+		for len(responses) < samples {
+			// Every additional call is a request of its own for the rate limiter
+			if len(responses) > 0 || correction != nil {
+				if err := Wait(ctx, content, llm); err != nil {
+					return answers, err
+				}
+			}
+
+			request := completion{system: llm.SystemPrompt, messages: history, samples: samples - len(responses), format: format, responseSchema: prompt.ResponseSchema}
+			if correction != nil {
+				request.messages = append(slices.Clone(history), correction...)
+				request.samples = 1
+			}
+			candidates, callAttempts, err := completeWithRetry(ctx, llm, validated, request)
+			// Number the attempts across the calls made for the prompt
+			offset := len(attempts)
+			for _, attempt := range callAttempts {
+				attempt.Attempt += offset
+				attempts = append(attempts, attempt)
+			}
+			if err != nil && rejected != "" && reasks < llm.MaxReasks && ctx.Err() == nil {
+				reasks++
+				logger.Info(fmt.Sprintf("Answer of %s rejected (%v). Asking for a correction (%d of %d).", LimiterKey(llm), err, reasks, llm.MaxReasks))
+				correction = append(correction,
+					message{role: roleAssistant, content: rejected},
+					message{role: roleUser, content: correctionPrompt(err, prompt.ResponseSchema)})
+				continue
+			}
+			if err != nil {
+				if llm.Retry != nil {
+					return answers, &RetryError{Attempts: attempts, Err: err}
+				}
+				return answers, err
+			}
+			if len(candidates) == 0 {
+				return answers, fmt.Errorf("no content in response from %s", llm.Provider)
+			}
+			responses = append(responses, candidates...)
+			promptRepaired = promptRepaired || repaired
+			correction = nil
+		}
+		responses = responses[:samples]
+
+		answers = append(answers, Answer{Responses: responses, Attempts: attempts, Repaired: promptRepaired, Reasks: reasks})
+		history = append(history, message{role: roleAssistant, content: responses[0]})
+		answered[prompt.SequenceNumber] = responses[0]
+	}
+
+	return answers, nil
+}
+
+// exampleAnswer returns the assistant turn of an example: its output as compact JSON, or the text
+// of a JSON string for prompts with the text response format.
+func exampleAnswer(output json.RawMessage, format string) string {
+	var text string
+	if format == formatText && json.Unmarshal(output, &text) == nil {
+		return text
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, output); err != nil {
+		return string(output)
+	}
+	return compact.String()
+}
@@ -0,0 +1,381 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+)
+
+func TestRunSequenceSamples(t *testing.T) {
+	tests := []struct {
+		name          string
+		nativeSamples bool
+		expectCalls   int
+	}{
+		{name: "Native sampling", nativeSamples: true, expectCalls: 2},
+		{name: "Repeated calls", nativeSamples: false, expectCalls: 6},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			var lastHistory []message
+			complete := func(ctx context.Context, request completion) ([]string, error) {
+				calls++
+				lastHistory = request.messages
+				count := 1
+				if tc.nativeSamples {
+					count = request.samples
+				}
+				candidates := []string{}
+				for i := 0; i < count; i++ {
+					candidates = append(candidates, fmt.Sprintf(`{"answer": "%d.%d"}`, len(request.messages)/2+1, calls*10+i))
+				}
+				return candidates, nil
+			}
+
+			llm := definitions.Model{Provider: "SelfHosted", Model: "local", Samples: 3}
+			answers, err := runSequence(context.Background(), promptsOf("first", "second"), llm, complete)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if calls != tc.expectCalls {
+				t.Errorf("expected %d calls, got %d", tc.expectCalls, calls)
+			}
+			if len(answers) != 2 || len(answers[0].Responses) != 3 || len(answers[1].Responses) != 3 {
+				t.Fatalf("expected 3 samples for each of 2 prompts, got %+v", answers)
+			}
+
+			// The first sample of the first prompt continues the conversation
+			if len(lastHistory) != 3 || lastHistory[1].role != roleAssistant || lastHistory[1].content != answers[0].Responses[0] {
+				t.Errorf("unexpected history for the second prompt: %+v", lastHistory)
+			}
+		})
+	}
+}
+
+func TestRunSequenceEmptyCandidates(t *testing.T) {
+	complete := func(ctx context.Context, request completion) ([]string, error) {
+		return nil, nil
+	}
+	_, err := runSequence(context.Background(), promptsOf("first"), definitions.Model{Provider: "SelfHosted"}, complete)
+	if err == nil {
+		t.Fatal("expected an error when the provider returns no candidates")
+	}
+}
+
+func TestRunSequenceKeepsEarlierAnswers(t *testing.T) {
+	failure := errors.New("provider down")
+	complete := func(ctx context.Context, request completion) ([]string, error) {
+		if len(request.messages) > 1 {
+			return nil, failure
+		}
+		return []string{`{"ok": true}`}, nil
+	}
+	answers, err := runSequence(context.Background(), promptsOf("first", "second"), definitions.Model{Provider: "SelfHosted"}, complete)
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected the provider error, got %v", err)
+	}
+	if len(answers) != 1 || answers[0].Responses[0] != `{"ok": true}` {
+		t.Errorf("expected the first answer to be kept, got %+v", answers)
+	}
+}
+
+func TestRunSequenceExtractsJSON(t *testing.T) {
+	complete := func(ctx context.Context, request completion) ([]string, error) {
+		return []string{"Here you go:\n```json\n{'a': 1,}\n```"}, nil
+	}
+
+	answers, err := runSequence(context.Background(), promptsOf("first"), definitions.Model{Provider: "Anthropic", RepairJSON: true}, complete)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if answers[0].Responses[0] != `{"a": 1}` || !answers[0].Repaired {
+		t.Errorf("expected the repaired JSON value, got %+v", answers[0])
+	}
+
+	_, err = runSequence(context.Background(), promptsOf("first"), definitions.Model{Provider: "Anthropic"}, complete)
+	if !errors.Is(err, ErrInvalidJSON) {
+		t.Errorf("expected ErrInvalidJSON without repair, got %v", err)
+	}
+}
+
+func TestRunSequenceReasks(t *testing.T) {
+	schema := json.RawMessage(`{"type": "object", "required": ["city"]}`)
+	tests := []struct {
+		name         string
+		maxReasks    int
+		answers      []string
+		expectError  bool
+		expectReasks int
+	}{
+		{name: "Corrected on the first follow-up", maxReasks: 2, answers: []string{`{"town": "Paris"}`, `{"city": "Paris"}`}, expectReasks: 1},
+		{name: "Corrected on the last follow-up", maxReasks: 2, answers: []string{`Paris`, `{"town": "Paris"}`, `{"city": "Paris"}`}, expectReasks: 2},
+		{name: "Follow-ups exhausted", maxReasks: 1, answers: []string{`Paris`, `{"town": "Paris"}`, `{"city": "Paris"}`}, expectError: true},
+		{name: "Follow-ups disabled", answers: []string{`{"town": "Paris"}`, `{"city": "Paris"}`}, expectError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var requests []completion
+			complete := func(ctx context.Context, request completion) ([]string, error) {
+				requests = append(requests, request)
+				return []string{tc.answers[len(requests)-1]}, nil
+			}
+
+			prompts := promptsOf("capital of France?", "and of Italy?")
+			prompts[0].ResponseSchema = schema
+			llm := definitions.Model{Provider: "SelfHosted", Model: "reask-" + tc.name, MaxReasks: tc.maxReasks}
+			answers, err := runSequence(context.Background(), prompts[:1], llm, complete)
+			if tc.expectError {
+				if !errors.Is(err, ErrSchemaMismatch) && !errors.Is(err, ErrInvalidJSON) {
+					t.Fatalf("expected a validation error, got %v", err)
+				}
+				if len(requests) != tc.maxReasks+1 {
+					t.Errorf("expected %d requests, got %d", tc.maxReasks+1, len(requests))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if answers[0].Responses[0] != `{"city": "Paris"}` || answers[0].Reasks != tc.expectReasks {
+				t.Errorf("unexpected answer %+v", answers[0])
+			}
+
+			// Each follow-up quotes the rejected answers and the validation error
+			last := requests[len(requests)-1].messages
+			if len(last) != 1+2*tc.expectReasks {
+				t.Fatalf("expected the prompt and %d corrective exchanges, got %+v", tc.expectReasks, last)
+			}
+			if last[1].role != roleAssistant || last[1].content != tc.answers[0] {
+				t.Errorf("expected the rejected answer in the conversation, got %+v", last[1])
+			}
+			if last[2].role != roleUser || !strings.Contains(last[2].content, "could not be used") {
+				t.Errorf("expected a corrective follow-up, got %+v", last[2])
+			}
+		})
+	}
+}
+
+func TestRunSequenceKeepsValidSamples(t *testing.T) {
+	var requested []int
+	complete := func(ctx context.Context, request completion) ([]string, error) {
+		requested = append(requested, request.samples)
+		if len(requested) == 1 {
+			return []string{`{"n": 1}`, `not json`, `{"n": 2}`}, nil
+		}
+		return []string{`{"n": 3}`}, nil
+	}
+
+	llm := definitions.Model{Provider: "SelfHosted", Model: "partial-samples", Samples: 3}
+	answers, err := runSequence(context.Background(), promptsOf("first"), llm, complete)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(requested, []int{3, 1}) {
+		t.Errorf("expected only the rejected sample to be requested again, got requests for %v", requested)
+	}
+	if !slices.Equal(answers[0].Responses, []string{`{"n": 1}`, `{"n": 2}`, `{"n": 3}`}) || answers[0].Reasks != 0 {
+		t.Errorf("expected the valid samples to be kept, got %+v", answers[0])
+	}
+}
+
+func TestRunSequenceResponseFormats(t *testing.T) {
+	var formats []string
+	complete := func(ctx context.Context, request completion) ([]string, error) {
+		formats = append(formats, request.format)
+		if request.format == formatText {
+			return []string{"The paper studies {coral} reefs."}, nil
+		}
+		return []string{"Sure: {\"reefs\": 3}"}, nil
+	}
+
+	// A free-text summary followed by a structured step in the same conversation
+	prompts := promptsOf("Summarize the abstract.", "How many reefs?")
+	prompts[0].ResponseFormat = formatText
+	answers, err := runSequence(context.Background(), prompts, definitions.Model{Provider: "OpenAI"}, complete)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if answers[0].Responses[0] != "The paper studies {coral} reefs." {
+		t.Errorf("expected the text answer as returned, got %q", answers[0].Responses[0])
+	}
+	if answers[1].Responses[0] != `{"reefs": 3}` {
+		t.Errorf("expected the JSON value of the second answer, got %q", answers[1].Responses[0])
+	}
+	if len(formats) != 2 || formats[0] != formatText || formats[1] != formatJSON {
+		t.Errorf("expected the text then json formats, got %v", formats)
+	}
+
+	invalid := []definitions.Prompt{
+		{PromptContent: "a", ResponseFormat: "yaml"},
+		{PromptContent: "b", ResponseFormat: formatJSONSchema},
+		{PromptContent: "c", ResponseFormat: formatText, ResponseSchema: json.RawMessage(`{"type": "object"}`)},
+	}
+	for _, prompt := range invalid {
+		if _, err := runSequence(context.Background(), []definitions.Prompt{prompt}, definitions.Model{Provider: "OpenAI"}, complete); err == nil {
+			t.Errorf("expected an error for format %q with schema %s", prompt.ResponseFormat, prompt.ResponseSchema)
+		}
+	}
+}
+
+func TestRunSequenceChainsAnswers(t *testing.T) {
+	for _, stateless := range []bool{false, true} {
+		var requests []completion
+		complete := func(ctx context.Context, request completion) ([]string, error) {
+			requests = append(requests, request)
+			return []string{`{"outcomes": ["mortality", "growth"]}`}, nil
+		}
+
+		prompts := promptsOf("List the outcomes.", "For each outcome in {{step1.outcomes}} extract the effect size.")
+		if _, err := runSequence(context.Background(), prompts, definitions.Model{Provider: "OpenAI", Stateless: stateless}, complete); err != nil {
+			t.Fatalf("stateless %v: unexpected error: %v", stateless, err)
+		}
+
+		last := requests[1].messages
+		expectMessages := 3
+		if stateless {
+			expectMessages = 1
+		}
+		if len(last) != expectMessages {
+			t.Errorf("stateless %v: expected %d messages, got %+v", stateless, expectMessages, last)
+		}
+		if content := last[len(last)-1].content; content != `For each outcome in ["mortality","growth"] extract the effect size.` {
+			t.Errorf("stateless %v: expected the resolved reference, got %q", stateless, content)
+		}
+	}
+
+	// A reference to a prompt not answered yet fails the prompt
+	complete := func(ctx context.Context, request completion) ([]string, error) {
+		return []string{`{"ok": true}`}, nil
+	}
+	answers, err := runSequence(context.Background(), promptsOf("First", "Use {{step3.x}}"), definitions.Model{Provider: "OpenAI"}, complete)
+	if !errors.Is(err, ErrUnresolvedReference) || len(answers) != 1 {
+		t.Errorf("expected ErrUnresolvedReference after one answer, got %v and %+v", err, answers)
+	}
+}
+
+func TestRunSequenceConditions(t *testing.T) {
+	excluded := definitions.Condition{Expression: "step1.included == true"}
+	tests := []struct {
+		name          string
+		otherwise     string
+		expectSent    []string
+		expectSkipped []bool
+	}{
+		{name: "Skip", otherwise: "", expectSent: []string{"Screen", "Design", "Sites"}, expectSkipped: []bool{false, true, false, false}},
+		{name: "Stop", otherwise: "stop", expectSent: []string{"Screen"}, expectSkipped: []bool{false, true, true, true}},
+		{name: "Jump", otherwise: "step4", expectSent: []string{"Screen", "Sites"}, expectSkipped: []bool{false, true, true, false}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var sent []string
+			complete := func(ctx context.Context, request completion) ([]string, error) {
+				sent = append(sent, request.messages[len(request.messages)-1].content)
+				return []string{`{"included": false}`}, nil
+			}
+
+			prompts := promptsOf("Screen", "Outcomes", "Design", "Sites")
+			condition := excluded
+			condition.Otherwise = tc.otherwise
+			prompts[1].Condition = &condition
+			answers, err := runSequence(context.Background(), prompts, definitions.Model{Provider: "OpenAI"}, complete)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(sent, tc.expectSent) {
+				t.Errorf("expected %v to be sent, got %v", tc.expectSent, sent)
+			}
+			if len(answers) != len(prompts) {
+				t.Fatalf("expected one answer per prompt, got %+v", answers)
+			}
+			for i, answer := range answers {
+				if answer.Skipped != tc.expectSkipped[i] || answer.Skipped && len(answer.Responses) != 0 {
+					t.Errorf("prompt %d: unexpected answer %+v", i+1, answer)
+				}
+			}
+		})
+	}
+
+	// Skipped prompts stay out of the conversation
+	var last []message
+	complete := func(ctx context.Context, request completion) ([]string, error) {
+		last = request.messages
+		return []string{`{"included": false}`}, nil
+	}
+	prompts := promptsOf("Screen", "Outcomes", "Summary")
+	prompts[1].Condition = &excluded
+	if _, err := runSequence(context.Background(), prompts, definitions.Model{Provider: "OpenAI"}, complete); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(last) != 3 || last[2].content != "Summary" {
+		t.Errorf("expected the first exchange and the last prompt, got %+v", last)
+	}
+}
+
+func TestRunSequenceExamples(t *testing.T) {
+	var requests []completion
+	complete := func(ctx context.Context, request completion) ([]string, error) {
+		requests = append(requests, request)
+		if request.format == formatText {
+			return []string{"A study of reefs."}, nil
+		}
+		return []string{`{"sites": ["Bonaire"]}`}, nil
+	}
+
+	prompts := promptsOf("Sites of: Reefs off Bonaire.", "Summarize the abstract.")
+	prompts[0].Examples = []definitions.Example{
+		{Input: "Sites of: Seagrass in Florida.", Output: json.RawMessage(`{ "sites": [ "Florida" ] }`)},
+	}
+	prompts[1].ResponseFormat = formatText
+	prompts[1].Examples = []definitions.Example{{Input: "Summarize: Kelp forests.", Output: json.RawMessage(`"A study of kelp."`)}}
+	answers, err := runSequence(context.Background(), prompts, definitions.Model{Provider: "OpenAI"}, complete)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(answers) != 2 || answers[0].Responses[0] != `{"sites": ["Bonaire"]}` {
+		t.Fatalf("expected only the answers to the prompts, got %+v", answers)
+	}
+
+	expected := []message{
+		{role: roleUser, content: "Sites of: Seagrass in Florida."},
+		{role: roleAssistant, content: `{"sites":["Florida"]}`},
+		{role: roleUser, content: "Sites of: Reefs off Bonaire."},
+		{role: roleAssistant, content: `{"sites": ["Bonaire"]}`},
+		{role: roleUser, content: "Summarize: Kelp forests."},
+		{role: roleAssistant, content: "A study of kelp."},
+		{role: roleUser, content: "Summarize the abstract."},
+	}
+	if !slices.Equal(requests[0].messages, expected[:3]) {
+		t.Errorf("unexpected first request %+v", requests[0].messages)
+	}
+	if !slices.Equal(requests[1].messages, expected) {
+		t.Errorf("unexpected second request %+v", requests[1].messages)
+	}
+
+	// Stateless requests keep the examples of their own prompt
+	requests = nil
+	if _, err := runSequence(context.Background(), prompts, definitions.Model{Provider: "OpenAI", Stateless: true}, complete); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(requests[1].messages, expected[4:]) {
+		t.Errorf("unexpected stateless request %+v", requests[1].messages)
+	}
+}
+
+// promptsOf builds a sequence of prompts with the given contents.
+func promptsOf(contents ...string) []definitions.Prompt {
+	prompts := []definitions.Prompt{}
+	for i, content := range contents {
+		prompts = append(prompts, definitions.Prompt{PromptContent: content, SequenceID: "1", SequenceNumber: i + 1})
+	}
+	return prompts
+}
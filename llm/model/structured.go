@@ -0,0 +1,137 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+	"github.com/open-and-sustainable/alembica/validation"
+)
+
+// Response formats a prompt can ask for.
+const (
+	formatText       = "text"        // Free text, stored as returned.
+	formatJSON       = "json"        // Any JSON value, in the provider's JSON mode where it has one.
+	formatJSONSchema = "json_schema" // JSON conforming to the response schema of the prompt.
+)
+
+// responseToolName names the tool that providers without a JSON Schema response format are
+// forced to call, so that its input carries the structured answer.
+const responseToolName = "respond"
+
+// responseToolDescription describes the forced response tool to the model.
+const responseToolDescription = "Record the answer to the user's request."
+
+// schemaObject decodes a response schema for the provider SDKs that take it as a map.
+//
+// Parameters:
+//   - schema: The JSON Schema of the prompt.
+//
+// Returns:
+//   - The decoded schema, or an error if it is not a JSON object.
+func schemaObject(schema json.RawMessage) (map[string]any, error) {
+	var object map[string]any
+	if err := json.Unmarshal(schema, &object); err != nil {
+		return nil, fmt.Errorf("invalid response schema: %v", err)
+	}
+	return object, nil
+}
+
+// responseToolSchema returns the input schema of the response tool for a request: its response
+// schema, when it has one describing a JSON object. Providers without a JSON Schema response
+// format force a call to the tool whenever one is returned; other schemas are only checked on
+// the answers.
+//
+// Parameters:
+//   - request: The completion request.
+//
+// Returns:
+//   - The decoded response schema, or nil if no tool is to be forced.
+//   - An error if the response schema is not a JSON object.
+func responseToolSchema(request completion) (map[string]any, error) {
+	if request.responseSchema == nil {
+		return nil, nil
+	}
+	schema, err := schemaObject(request.responseSchema)
+	if err != nil || !isObjectSchema(schema) {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// isObjectSchema reports whether a schema describes a JSON object, as required for the input
+// schema of the tool that providers are forced to call.
+func isObjectSchema(schema map[string]any) bool {
+	return schema["type"] == "object"
+}
+
+// responseFormat returns the response format of a prompt. Without an explicit format, prompts
+// with a response schema ask for json_schema and the others for json.
+//
+// Parameters:
+//   - prompt: The prompt.
+//
+// Returns:
+//   - The response format.
+//   - An error if the format is unknown or does not agree with the presence of a response schema.
+func responseFormat(prompt definitions.Prompt) (string, error) {
+	hasSchema := len(prompt.ResponseSchema) > 0
+	switch prompt.ResponseFormat {
+	case "":
+		if hasSchema {
+			return formatJSONSchema, nil
+		}
+		return formatJSON, nil
+	case formatText, formatJSON:
+		if hasSchema {
+			return "", fmt.Errorf("response format %s does not take a response schema", prompt.ResponseFormat)
+		}
+	case formatJSONSchema:
+		if !hasSchema {
+			return "", fmt.Errorf("response format %s requires a response schema", prompt.ResponseFormat)
+		}
+	default:
+		return "", fmt.Errorf("unknown response format %q", prompt.ResponseFormat)
+	}
+	return prompt.ResponseFormat, nil
+}
+
+// validateAnswer extracts the JSON value of an answer and validates it against the response
+// schema of the prompt.
+//
+// Parameters:
+//   - text: The answer returned by the provider.
+//   - schema: The JSON Schema of the prompt, or nil if it has none.
+//   - repair: Whether defective JSON may be repaired.
+//
+// Returns:
+//   - The extracted value and whether it was repaired.
+//   - An error wrapping ErrInvalidJSON or ErrSchemaMismatch if the answer cannot be used.
+func validateAnswer(text string, schema json.RawMessage, repair bool) (ExtractedJSON, error) {
+	extracted, err := ExtractJSON(text, repair)
+	if err != nil {
+		return ExtractedJSON{}, err
+	}
+	if len(schema) > 0 {
+		if err := validation.ValidateResponse(extracted.JSON, string(schema)); err != nil {
+			return ExtractedJSON{}, fmt.Errorf("%w: %v", ErrSchemaMismatch, err)
+		}
+	}
+	return extracted, nil
+}
+
+// correctionPrompt asks the model to fix an answer that failed validation.
+//
+// Parameters:
+//   - err: The validation error of the answer.
+//   - schema: The JSON Schema of the prompt, or nil if it has none.
+//
+// Returns:
+//   - The text of the corrective follow-up turn.
+func correctionPrompt(err error, schema json.RawMessage) string {
+	text := fmt.Sprintf("Your previous answer could not be used: %v. Reply again with the corrected answer as valid JSON only, without any other text.", err)
+	if len(schema) > 0 {
+		text += " The answer must conform to this JSON Schema: " + string(schema)
+	}
+	return text
+}
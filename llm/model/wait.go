@@ -1,6 +1,7 @@
 package model
 
 import (
+	"context"
 	"fmt"
 	"github.com/open-and-sustainable/alembica/definitions"
 	"github.com/open-and-sustainable/alembica/llm/tokens"
@@ -9,48 +10,97 @@ import (
 	"time"
 )
 
-// Global variables for tracking request timestamps to enforce rate limits.
-var requestTimestamps []time.Time
+// rateLimiter tracks the requests sent to one provider/model pair.
+type rateLimiter struct {
+	gate              chan struct{} // Serializes waits so concurrent workers take turns.
+	requestTimestamps []time.Time
+}
+
+// Global registry of rate limiters, one per provider/model pair.
+var limiters = map[string]*rateLimiter{}
 var mutex sync.Mutex
 
+// LimiterKey returns the key under which requests to a model share rate limits.
+// Models with the same key share one request history, so workers running in parallel
+// against the same provider/model are throttled together.
+//
+// Parameters:
+//   - llm: The model configuration.
+//
+// Returns:
+//   - The key "<provider>/<model>".
+func LimiterKey(llm definitions.Model) string {
+	return llm.Provider + "/" + llm.Model
+}
+
+// limiterFor returns the rate limiter of the model, creating it on first use.
+func limiterFor(llm definitions.Model) *rateLimiter {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	key := LimiterKey(llm)
+	limiter, exists := limiters[key]
+	if !exists {
+		limiter = &rateLimiter{gate: make(chan struct{}, 1)}
+		limiters[key] = limiter
+	}
+	return limiter
+}
+
 // Wait enforces rate limits by delaying execution based on token per minute (TPM) and request per minute (RPM) constraints.
+// Callers querying the same provider/model wait one at a time, so concurrent workers stay within the shared limits.
 //
 // Parameters:
+//   - ctx: The context that can interrupt the wait.
 //   - prompt: The text prompt being processed.
 //   - llm: The model configuration containing rate limits.
-func Wait(prompt string, llm definitions.Model) {
-	waitTime := getWaitTime(prompt, llm)
-	waitWithStatus(waitTime)
+//
+// Returns:
+//   - An error if ctx is cancelled or expires before the wait completes.
+func Wait(ctx context.Context, prompt string, llm definitions.Model) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	limiter := limiterFor(llm)
+	select {
+	case limiter.gate <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-limiter.gate }()
+
+	waitTime := limiter.getWaitTime(ctx, prompt, llm)
+	return waitWithStatus(ctx, waitTime)
 }
 
 // getWaitTime calculates the required wait time in seconds based on TPM and RPM limits.
+// It must be called while holding the limiter gate.
 //
 // Parameters:
+//   - ctx: The context that can interrupt token counting.
 //   - prompt: The text prompt being processed.
 //   - llm: The model configuration containing rate limits.
 //
 // Returns:
 //   - The number of seconds to wait before the next request.
-func getWaitTime(prompt string, llm definitions.Model) int {
-	mutex.Lock()
-	defer mutex.Unlock()
-
+func (limiter *rateLimiter) getWaitTime(ctx context.Context, prompt string, llm definitions.Model) int {
 	// Clean up old timestamps (older than 60 seconds)
 	now := time.Now()
 	cutoff := now.Add(-60 * time.Second)
 	validTimestamps := []time.Time{}
-	for _, timestamp := range requestTimestamps {
+	for _, timestamp := range limiter.requestTimestamps {
 		if timestamp.After(cutoff) {
 			validTimestamps = append(validTimestamps, timestamp)
 		}
 	}
-	requestTimestamps = validTimestamps
+	limiter.requestTimestamps = validTimestamps
 
 	// Add the current request timestamp
-	requestTimestamps = append(requestTimestamps, now)
+	limiter.requestTimestamps = append(limiter.requestTimestamps, now)
 
 	// Get the current number of requests in the last 60 seconds
-	numRequests := len(requestTimestamps)
+	numRequests := len(limiter.requestTimestamps)
 	remainingSeconds := 60 - now.Second()
 
 	// Analyze TPM limits
@@ -58,7 +108,7 @@ func getWaitTime(prompt string, llm definitions.Model) int {
 	tpmLimit := llm.TPMLimit
 	if tpmLimit > 0 {
 		counter := tokens.RealTokenCounter{}
-		tokenCount := counter.GetNumTokensFromPrompt(prompt, llm.Provider, llm.Model, llm.APIKey)
+		tokenCount := counter.CountModelTokens(ctx, prompt, llm)
 		tokensPerSecond := float64(tpmLimit) / 60.0
 		requiredWaitTime := float64(tokenCount) / tokensPerSecond
 		if requiredWaitTime > float64(remainingSeconds) {
@@ -83,19 +133,35 @@ func getWaitTime(prompt string, llm definitions.Model) int {
 // waitWithStatus enforces a waiting period, displaying status updates every 5 seconds.
 //
 // Parameters:
+//   - ctx: The context that can interrupt the wait.
 //   - waitTime: The number of seconds to wait.
-func waitWithStatus(waitTime int) {
+//
+// Returns:
+//   - The context error if ctx is done before the wait completes, nil otherwise.
+func waitWithStatus(ctx context.Context, waitTime int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if waitTime <= 0 {
+		return nil
+	}
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 	remainingTime := waitTime
-	for range ticker.C {
-		if remainingTime%5 == 0 {
-			logger.Info(fmt.Sprintf("Waiting... %d seconds remaining", remainingTime))
-		}
-		remainingTime--
-		if remainingTime <= 0 {
-			logger.Info("Wait completed.")
-			break
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info(fmt.Sprintf("Wait interrupted with %d seconds remaining: %v", remainingTime, ctx.Err()))
+			return ctx.Err()
+		case <-ticker.C:
+			if remainingTime%5 == 0 {
+				logger.Info(fmt.Sprintf("Waiting... %d seconds remaining", remainingTime))
+			}
+			remainingTime--
+			if remainingTime <= 0 {
+				logger.Info("Wait completed.")
+				return nil
+			}
 		}
 	}
 }
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+)
+
+func TestWaitWithStatusCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	err := waitWithStatus(ctx, 30)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("wait was not interrupted promptly: %v", elapsed)
+	}
+}
+
+func TestWaitWithStatusNoWait(t *testing.T) {
+	start := time.Now()
+	if err := waitWithStatus(context.Background(), 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("zero wait should return immediately, took %v", elapsed)
+	}
+}
+
+func TestWaitExpiredContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	err := Wait(ctx, "prompt", definitions.Model{Provider: "SelfHosted", Model: "local"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestLimitersArePerModel(t *testing.T) {
+	limited := definitions.Model{Provider: "OpenAI", Model: "gpt-4o", RPMLimit: 3}
+	other := definitions.Model{Provider: "OpenAI", Model: "gpt-4o-mini", RPMLimit: 3}
+
+	if limiterFor(limited) != limiterFor(limited) {
+		t.Fatal("expected the same limiter for the same provider/model")
+	}
+	if limiterFor(limited) == limiterFor(other) {
+		t.Fatal("expected distinct limiters for different models")
+	}
+
+	limiter := &rateLimiter{}
+	if wait := limiter.getWaitTime(context.Background(), "prompt", limited); wait != 0 {
+		t.Errorf("expected no wait for the first request, got %d", wait)
+	}
+	if wait := limiter.getWaitTime(context.Background(), "prompt", limited); wait <= 0 {
+		t.Errorf("expected a wait once the RPM limit is reached, got %d", wait)
+	}
+	if wait := (&rateLimiter{}).getWaitTime(context.Background(), "prompt", other); wait != 0 {
+		t.Errorf("expected no wait for a model with its own history, got %d", wait)
+	}
+}
@@ -8,6 +8,7 @@ Supported LLM Providers:
   - Cohere (Command-R, Command-R+, Command-R7B)
   - Anthropic (via OpenAI token counting)
   - DeepSeek (via OpenAI token counting)
+  - Mistral (via OpenAI token counting)
 
 Core Components:
   - TokenCounter Interface:
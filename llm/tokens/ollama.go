@@ -0,0 +1,63 @@
+package tokens
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+	"github.com/open-and-sustainable/alembica/utils/logger"
+)
+
+// ollamaTokenizeTimeout bounds a tokenize call, since rate-limit waits of the model hold their
+// turn while counting.
+var ollamaTokenizeTimeout = 10 * time.Second
+
+// numTokensFromPromptOllama counts tokens with the tokenize endpoint of the Ollama server of the
+// model: its base URL, or else the server set by OLLAMA_HOST, or the local one. Servers without
+// the endpoint, or that do not answer in time, count zero tokens.
+func numTokensFromPromptOllama(ctx context.Context, prompt string, llm definitions.Model) (numTokens int) {
+	host := cmp.Or(llm.BaseURL, os.Getenv("OLLAMA_HOST"), "http://localhost:11434")
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ollamaTokenizeTimeout)
+	defer cancel()
+
+	payload, _ := json.Marshal(map[string]string{"model": llm.Model, "content": prompt})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(host, "/")+"/api/tokenize", bytes.NewReader(payload))
+	if err != nil {
+		logger.Error(err)
+		return 0
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if llm.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+llm.APIKey)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		logger.Info(fmt.Sprintf("Token counting not available from Ollama: %v", err))
+		return 0
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logger.Info(fmt.Sprintf("Token counting not available from Ollama: HTTP %d", resp.StatusCode))
+		return 0
+	}
+	var response struct {
+		Tokens []int `json:"tokens"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		logger.Error(err)
+		return 0
+	}
+	return len(response.Tokens)
+}
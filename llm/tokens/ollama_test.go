@@ -0,0 +1,89 @@
+package tokens
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/open-and-sustainable/alembica/definitions"
+)
+
+// newTokenizeServer starts an Ollama stand-in whose tokenize endpoint counts the words of the content.
+func newTokenizeServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tokenize" {
+			http.NotFound(w, r)
+			return
+		}
+		var request struct {
+			Content string `json:"content"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		tokens := make([]int, len(strings.Fields(request.Content)))
+		json.NewEncoder(w).Encode(map[string]any{"tokens": tokens})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNumTokensFromPromptOllama(t *testing.T) {
+	server := newTokenizeServer(t)
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+
+	tests := []struct {
+		name    string
+		host    string // OLLAMA_HOST
+		baseURL string
+		want    int
+	}{
+		{name: "Server from OLLAMA_HOST", host: server.URL, want: 3},
+		{name: "OLLAMA_HOST without scheme", host: strings.TrimPrefix(server.URL, "http://"), want: 3},
+		{name: "Base URL of the model first", host: missing.URL, baseURL: server.URL + "/", want: 3},
+		{name: "Server without the endpoint", host: server.URL, baseURL: missing.URL, want: 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("OLLAMA_HOST", tc.host)
+			llm := definitions.Model{Provider: "Ollama", Model: "llama3.1", BaseURL: tc.baseURL}
+			if got := numTokensFromPromptOllama(context.Background(), "What is AI?", llm); got != tc.want {
+				t.Errorf("expected %d tokens, got %d", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestNumTokensFromPromptOllamaHangingServer(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	llm := definitions.Model{Provider: "Ollama", Model: "llama3.1", BaseURL: server.URL}
+
+	// A cancelled context stops the call
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if got := numTokensFromPromptOllama(ctx, "What is AI?", llm); got != 0 || time.Since(start) > 2*time.Second {
+		t.Errorf("expected zero tokens once the context is done, got %d after %v", got, time.Since(start))
+	}
+
+	// Without a deadline, the call gives up after the tokenize timeout
+	defer func(timeout time.Duration) { ollamaTokenizeTimeout = timeout }(ollamaTokenizeTimeout)
+	ollamaTokenizeTimeout = 50 * time.Millisecond
+	start = time.Now()
+	if got := numTokensFromPromptOllama(context.Background(), "What is AI?", llm); got != 0 || time.Since(start) > 2*time.Second {
+		t.Errorf("expected zero tokens after the timeout, got %d after %v", got, time.Since(start))
+	}
+}
@@ -1,10 +1,34 @@
 package tokens
 
 import (
+	"context"
 	"fmt"
+	"sync"
+
+	"github.com/open-and-sustainable/alembica/definitions"
 	"github.com/open-and-sustainable/alembica/utils/logger"
 )
 
+// Token counters of the providers registered with RegisterCounter.
+var (
+	countersMu sync.RWMutex
+	counters   = map[string]func(ctx context.Context, prompt string, llm definitions.Model) int{}
+)
+
+// RegisterCounter sets how GetNumTokensFromPrompt counts the tokens of a provider that is not
+// built into alembica. It is called by model.RegisterProvider.
+//
+// Parameters:
+//   - provider: The name of the provider.
+//   - count: Returns the number of tokens in a prompt for a model configuration, such as its
+//     model name, API key and base URL, giving up when ctx is done; nil if the provider cannot
+//     count tokens, in which case zero is returned.
+func RegisterCounter(provider string, count func(ctx context.Context, prompt string, llm definitions.Model) int) {
+	countersMu.Lock()
+	defer countersMu.Unlock()
+	counters[provider] = count
+}
+
 // TokenCounter defines an interface for counting tokens in text prompts.
 // It requires an implementation that can handle different providers and models,
 // using provider-specific logic to interact with APIs or SDKs.
@@ -30,8 +54,24 @@ type RealTokenCounter struct{}
 // Returns:
 //   - An integer representing the number of tokens in the prompt, or zero if the provider is unsupported.
 //
-// The function logs an error and returns zero if the provider is not supported.
+// Providers registered with RegisterCounter use their own counter. The function logs an error
+// and returns zero if the provider is not supported.
 func (rtc RealTokenCounter) GetNumTokensFromPrompt(prompt string, provider string, model string, key string) int {
+	return rtc.CountModelTokens(context.Background(), prompt, definitions.Model{Provider: provider, Model: model, APIKey: key})
+}
+
+// CountModelTokens calculates the number of tokens in a prompt like GetNumTokensFromPrompt, for a
+// whole model configuration, so that counters can reach the endpoint the model is served from.
+//
+// Arguments:
+//   - ctx: The context that can interrupt counters calling a remote endpoint.
+//   - prompt: The input text to be analyzed.
+//   - llm: The model configuration, naming the provider and model.
+//
+// Returns:
+//   - An integer representing the number of tokens in the prompt, or zero if the provider is unsupported.
+func (rtc RealTokenCounter) CountModelTokens(ctx context.Context, prompt string, llm definitions.Model) int {
+	provider, model, key := llm.Provider, llm.Model, llm.APIKey
 	var numTokens int
 	switch provider {
 	case "OpenAI":
@@ -46,12 +86,26 @@ func (rtc RealTokenCounter) GetNumTokensFromPrompt(prompt string, provider strin
 		numTokens = numTokensFromPromptOpenAI(prompt, "gpt-4o", key)
 	case "Perplexity":
 		numTokens = numTokensFromPromptOpenAI(prompt, "gpt-4o", key)
+	case "Mistral":
+		numTokens = numTokensFromPromptOpenAI(prompt, "gpt-4o", key)
+	case "Ollama":
+		numTokens = numTokensFromPromptOllama(ctx, prompt, llm)
 	case "AWSBedrock", "AzureAI", "VertexAI", "SelfHosted":
 		logger.Info(fmt.Sprintf("Token counting not supported for provider: %s", provider))
 		return 0
 	default:
-		logger.Error(fmt.Sprintf("Unsupported LLM provider: %s", provider))
-		return 0
+		countersMu.RLock()
+		count, registered := counters[provider]
+		countersMu.RUnlock()
+		if !registered {
+			logger.Error(fmt.Sprintf("Unsupported LLM provider: %s", provider))
+			return 0
+		}
+		if count == nil {
+			logger.Info(fmt.Sprintf("Token counting not supported for provider: %s", provider))
+			return 0
+		}
+		numTokens = count(ctx, prompt, llm)
 	}
 	return numTokens
 }
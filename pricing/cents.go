@@ -2,6 +2,8 @@ package pricing
 
 import (
 	"fmt"
+	"sync"
+
 	"github.com/open-and-sustainable/alembica/utils/logger"
 
 	"github.com/shopspring/decimal"
@@ -22,6 +24,7 @@ import (
 // - Anthropic: Claude models (3.5 Sonnet, 3 Haiku, 3 Opus, etc.)
 // - DeepSeek: DeepSeek Chat and Reasoner models
 // - Perplexity: Sonar models (Sonar, Sonar Pro, Sonar Reasoning Pro, etc.)
+// - Mistral: Mistral, Magistral, Ministral and Codestral models
 //
 // Note: Some models like Google's Gemini have tiered pricing that depends
 // on token count thresholds, which is handled in the numCentsFromTokens function.
@@ -78,6 +81,62 @@ var modelRates = map[string]decimal.Decimal{ // dollar prices per input M token
 	"sonar-pro":                         decimal.NewFromFloat(3.00).Div(decimal.NewFromInt(1000000)),
 	"sonar-reasoning-pro":               decimal.NewFromFloat(2.00).Div(decimal.NewFromInt(1000000)),
 	"sonar-deep-research":               decimal.NewFromFloat(2.00).Div(decimal.NewFromInt(1000000)),
+	"mistral-large-latest":              decimal.NewFromFloat(0.50).Div(decimal.NewFromInt(1000000)),
+	"mistral-medium-latest":             decimal.NewFromFloat(0.40).Div(decimal.NewFromInt(1000000)),
+	"mistral-small-latest":              decimal.NewFromFloat(0.10).Div(decimal.NewFromInt(1000000)),
+	"magistral-medium-latest":           decimal.NewFromFloat(2.00).Div(decimal.NewFromInt(1000000)),
+	"magistral-small-latest":            decimal.NewFromFloat(0.50).Div(decimal.NewFromInt(1000000)),
+	"ministral-8b-latest":               decimal.NewFromFloat(0.10).Div(decimal.NewFromInt(1000000)),
+	"ministral-3b-latest":               decimal.NewFromFloat(0.04).Div(decimal.NewFromInt(1000000)),
+	"codestral-latest":                  decimal.NewFromFloat(0.30).Div(decimal.NewFromInt(1000000)),
+	"open-mistral-nemo":                 decimal.NewFromFloat(0.15).Div(decimal.NewFromInt(1000000)),
+}
+
+// Input token rates of the providers registered with RegisterPrices, by provider and model.
+var (
+	providerRatesMu sync.RWMutex
+	providerRates   = map[string]map[string]decimal.Decimal{}
+)
+
+// RegisterPrices sets the input prices of the models of a provider that is not built into
+// alembica, used by ComputeCosts. It is called by model.RegisterProvider.
+//
+// Parameters:
+//   - provider: The name of the provider.
+//   - prices: Dollar prices per million input tokens, by model. Models without a price cost zero.
+func RegisterPrices(provider string, prices map[string]float64) {
+	rates := make(map[string]decimal.Decimal, len(prices))
+	for model, price := range prices {
+		rates[model] = decimal.NewFromFloat(price).Div(decimal.NewFromInt(1000000))
+	}
+	providerRatesMu.Lock()
+	defer providerRatesMu.Unlock()
+	providerRates[provider] = rates
+}
+
+// providerNumCentsFromTokens calculates the cost of the tokens of a registered provider.
+//
+// Parameters:
+//   - numTokens: The number of tokens used in the request.
+//   - provider: The provider processing the request.
+//   - model: The model identifier used for processing the request.
+//
+// Returns:
+//   - The computed cost as a decimal.Decimal value.
+//   - Whether the provider was registered with RegisterPrices.
+func providerNumCentsFromTokens(numTokens int, provider string, model string) (decimal.Decimal, bool) {
+	providerRatesMu.RLock()
+	rates, registered := providerRates[provider]
+	providerRatesMu.RUnlock()
+	if !registered {
+		return decimal.Zero, false
+	}
+	rate, ok := rates[model]
+	if !ok {
+		logger.Info(fmt.Sprintf("Cost estimation unavailable because model not found: %s", model))
+		return decimal.Zero, true
+	}
+	return decimal.NewFromInt(int64(numTokens)).Mul(rate), true
 }
 
 // numCentsFromTokens calculates the cost in cents based on token usage and model pricing.
@@ -7,10 +7,12 @@ Supported LLM Providers:
   - Cohere (Command-R, Command-R+, Command-R7B)
   - Anthropic (Claude-3.5-Sonnet, Claude-3-Haiku, Claude-3-Opus)
   - DeepSeek (DeepSeek-Chat)
+  - Mistral (Mistral Large, Mistral Medium, Mistral Small, Magistral, Ministral, Codestral)
 
 Core Components:
   - Cost Calculation:
   - `ComputeCosts`: Processes prompts and calculates their associated costs.
+  - `ComputeCostsWithOptions`: Does the same with options for expanding prompt templates.
   - `assessPromptCost`: Computes the cost of an individual prompt.
   - Token-Based Pricing:
   - `numCentsFromTokens`: Converts token counts into cost estimates.
@@ -29,7 +29,22 @@ func ComputeCosts(jsonInput string, version ...string) (string, error) {
 	if len(version) > 0 {
 		v = version[0]
 	}
+	return ComputeCostsWithOptions(jsonInput, v)
+}
 
+// ComputeCostsWithOptions calculates the cost of processing input prompts like ComputeCosts,
+// expanding the prompt templates of the input with the given options, such as
+// definitions.WithRecordDir to price records read from files.
+//
+// Parameters:
+//   - jsonInput: A JSON string containing the input data.
+//   - v: The schema version to validate against.
+//   - opts: The options for expanding prompt templates.
+//
+// Returns:
+//   - A JSON string containing computed cost details.
+//   - An error if input validation, template expansion, cost computation, or output validation fails.
+func ComputeCostsWithOptions(jsonInput string, v string, opts ...definitions.TemplateOption) (string, error) {
 	// Validate input JSON
 	if err := validation.ValidateInput(jsonInput, v); err != nil {
 		logger.Error("Invalid input JSON:", err)
@@ -43,6 +58,10 @@ func ComputeCosts(jsonInput string, version ...string) (string, error) {
 		logger.Error("Failed to parse JSON input:", err)
 		return "", err
 	}
+	if input, err = definitions.ExpandTemplates(input, opts...); err != nil {
+		logger.Error("Failed to expand prompt templates:", err)
+		return "", err
+	}
 
 	// Initialize cost tracking structure
 	costOutput := definitions.CostOutput{
@@ -123,6 +142,9 @@ func ComputeCosts(jsonInput string, version ...string) (string, error) {
 //   - An error if token counting fails.
 func assessPromptCost(prompt string, provider string, model string, key string) (decimal.Decimal, error) {
 	numTokens := tokenCounter.GetNumTokensFromPrompt(prompt, provider, model, key)
+	if numCents, registered := providerNumCentsFromTokens(numTokens, provider, model); registered {
+		return numCents, nil
+	}
 	numCents := numCentsFromTokens(numTokens, model)
 	return numCents, nil
 }
@@ -2,7 +2,12 @@ package pricing
 
 import (
 	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/open-and-sustainable/alembica/definitions"
 )
 
 func TestComputeCosts(t *testing.T) {
@@ -76,3 +81,33 @@ func TestComputeCosts(t *testing.T) {
 		}
 	}
 }
+
+func TestComputeCostsWithRecordSource(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "papers.csv"), []byte("id,abstract\n1,Corals\n2,Kelp\n"), 0644)
+	inputJSON := `{
+		"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-17T12:00:00Z"},
+		"models": [{"provider": "OpenAI", "model": "gpt-4-turbo", "temperature": 1.0}],
+		"prompts": [],
+		"templates": {
+			"prompts": [{"promptContent": "Summarize: {{abstract}}", "sequenceNumber": 1}],
+			"source": {"path": "papers.csv"}
+		}
+	}`
+
+	if _, err := ComputeCosts(inputJSON, "v2"); err == nil || !strings.Contains(err.Error(), "is not allowed") {
+		t.Errorf("expected the record source to be rejected without a record directory, got %v", err)
+	}
+
+	resultJSON, err := ComputeCostsWithOptions(inputJSON, "v2", definitions.WithRecordDir(dir))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var costs definitions.CostOutput
+	if err := json.Unmarshal([]byte(resultJSON), &costs); err != nil {
+		t.Fatalf("invalid cost JSON: %v", err)
+	}
+	if len(costs.Costs) == 0 {
+		t.Errorf("expected costs for the records of the file, got %+v", costs)
+	}
+}
@@ -159,12 +159,16 @@ func verifyContextMaintenance(t *testing.T, responsesByModelAndSequence map[stri
 	for modelKey, sequenceMap := range responsesByModelAndSequence {
 		// Check chat1 context (name remembering)
 		if responses, ok := sequenceMap["chat1"]; ok && len(responses) >= 2 {
-			verifyNameRemembered(t, modelKey, responses[1].ModelResponses[0])
+			if responseText, answered := answerOf(t, modelKey, responses[1]); answered {
+				verifyNameRemembered(t, modelKey, responseText)
+			}
 		}
 
 		// Check chat2 context (capital remembering)
 		if responses, ok := sequenceMap["chat2"]; ok && len(responses) >= 2 {
-			verifyCapitalRemembered(t, modelKey, responses[1].ModelResponses[0])
+			if responseText, answered := answerOf(t, modelKey, responses[1]); answered {
+				verifyCapitalRemembered(t, modelKey, responseText)
+			}
 		}
 	}
 }
@@ -230,7 +234,10 @@ func verifyContextIsolation(t *testing.T, responsesByModelAndSequence map[string
 	for modelKey, sequenceMap := range responsesByModelAndSequence {
 		if responses, ok := sequenceMap["chat2"]; ok {
 			for _, response := range responses {
-				responseText := response.ModelResponses[0]
+				responseText, answered := answerOf(t, modelKey, response)
+				if !answered {
+					continue
+				}
 				if strings.Contains(strings.ToLower(responseText), "testuser") {
 					t.Errorf("[%s] Context leakage between sequences: chat2 knows about TestUser from chat1", modelKey)
 					t.Logf("Response content: %s", responseText)
@@ -239,3 +246,18 @@ func verifyContextIsolation(t *testing.T, responsesByModelAndSequence map[string
 		}
 	}
 }
+
+// answerOf returns the first answer of a response. Failed pairs are reported in the output with
+// an error and no answers, which fails the test instead of being indexed.
+func answerOf(t *testing.T, modelKey string, response definitions.Response) (string, bool) {
+	t.Helper()
+	if response.Error != nil {
+		t.Errorf("[%s] Sequence %s, prompt %d failed: %s (%s)", modelKey, response.SequenceID, response.SequenceNumber, response.Error.Message, response.Error.Category)
+		return "", false
+	}
+	if len(response.ModelResponses) == 0 {
+		t.Errorf("[%s] Sequence %s, prompt %d has no answer", modelKey, response.SequenceID, response.SequenceNumber)
+		return "", false
+	}
+	return response.ModelResponses[0], true
+}
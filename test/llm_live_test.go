@@ -2,6 +2,7 @@ package test
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"strings"
@@ -98,9 +99,9 @@ func TestLiveQueryLLM(t *testing.T) {
 	// Anthropic disabled temporarily due to billing issues in live tests.
 	providers := []string{"OpenAI", "GoogleAI", "Cohere", "DeepSeek", "Perplexity"}
 
-	prompts := []string{
-		"Please provide a JSON response: { \"question\": \"What is the capital of France?\" }",
-		"Respond only in JSON format: { \"request\": \"Tell me a joke.\" }",
+	prompts := []definitions.Prompt{
+		{PromptContent: "Please provide a JSON response: { \"question\": \"What is the capital of France?\" }", SequenceID: "1", SequenceNumber: 1},
+		{PromptContent: "Respond only in JSON format: { \"request\": \"Tell me a joke.\" }", SequenceID: "1", SequenceNumber: 2},
 	}
 
 	queryService := model.DefaultQueryService{}
@@ -114,7 +115,7 @@ func TestLiveQueryLLM(t *testing.T) {
 		}
 
 		// **Select the correct model for the provider**
-		modelName := check.GetModel(prompts[0], provider, "", apiKey)
+		modelName := check.GetModel(prompts[0].PromptContent, provider, "", apiKey)
 
 		if modelName == "" {
 			t.Logf("Skipping %s: No supported model found", provider)
@@ -131,7 +132,7 @@ func TestLiveQueryLLM(t *testing.T) {
 
 			t.Logf("Testing %s with model %s...", provider, modelName)
 			start := time.Now()
-			responses, err := queryService.QueryLLM(prompts, llm)
+			responses, err := queryService.QueryLLM(context.Background(), prompts, llm)
 			elapsed := time.Since(start)
 
 			if err != nil {
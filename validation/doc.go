@@ -11,6 +11,8 @@ Core Functions:
   - Validates a JSON string against a specified schema version and type.
   - ValidateInput:
   - Checks if input data conforms to the defined input schema.
+  - ValidateInputHeader, ValidateInputLine, ValidateOutputLine:
+  - Validate single lines of JSON Lines inputs and outputs against the same schemas.
 
 Features:
   - Uses `gojsonschema` for schema-based validation.
@@ -0,0 +1,99 @@
+package validation
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// placeholderTimestamp fills the required input timestamp when a single line is validated.
+const placeholderTimestamp = "1970-01-01T00:00:00Z"
+
+// ValidateInputHeader checks the header line of a JSON Lines input, holding the metadata and
+// models, against the input schema.
+//
+// Parameters:
+//   - jsonString: The header line.
+//   - version: The schema version to use.
+//
+// Returns:
+//   - An error if validation fails, or nil if it succeeds.
+func ValidateInputHeader(jsonString string, version string) error {
+	header, err := parseLine(jsonString)
+	if err != nil {
+		return err
+	}
+	if _, exists := header["prompts"]; exists {
+		return fmt.Errorf("validation errors: header line must not contain prompts")
+	}
+	header["prompts"] = json.RawMessage(`[]`)
+	return validateWrapped(header, version, ValidateInput, "")
+}
+
+// ValidateInputLine checks one prompt line of a JSON Lines input against the prompt
+// definition of the input schema.
+//
+// Parameters:
+//   - jsonString: The prompt line.
+//   - version: The schema version to use.
+//
+// Returns:
+//   - An error if validation fails, or nil if it succeeds.
+func ValidateInputLine(jsonString string, version string) error {
+	if _, err := parseLine(jsonString); err != nil {
+		return err
+	}
+	metadata, _ := json.Marshal(map[string]string{"schemaVersion": version, "timestamp": placeholderTimestamp})
+	document := map[string]json.RawMessage{
+		"metadata": metadata,
+		"models":   json.RawMessage(`[]`),
+		"prompts":  json.RawMessage("[" + jsonString + "]"),
+	}
+	return validateWrapped(document, version, ValidateInput, "prompts.0")
+}
+
+// ValidateOutputLine checks one response line of a JSON Lines output against the response
+// definition of the output schema.
+//
+// Parameters:
+//   - jsonString: The response line.
+//   - version: The schema version to use.
+//
+// Returns:
+//   - An error if validation fails, or nil if it succeeds.
+func ValidateOutputLine(jsonString string, version string) error {
+	if _, err := parseLine(jsonString); err != nil {
+		return err
+	}
+	metadata, _ := json.Marshal(map[string]string{"schemaVersion": version})
+	document := map[string]json.RawMessage{
+		"metadata":  metadata,
+		"responses": json.RawMessage("[" + jsonString + "]"),
+	}
+	return validateWrapped(document, version, ValidateOutput, "responses.0")
+}
+
+// parseLine checks that a line holds a single JSON object.
+func parseLine(jsonString string) (map[string]json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(jsonString), &fields); err != nil {
+		return nil, fmt.Errorf("line is not a JSON object: %v", err)
+	}
+	return fields, nil
+}
+
+// validateWrapped validates a line embedded in a full document and strips the path of the
+// embedding from the reported errors.
+func validateWrapped(document map[string]json.RawMessage, version string, validate func(string, string) error, path string) error {
+	documentJSON, err := json.Marshal(document)
+	if err != nil {
+		return fmt.Errorf("error during validation: %v", err)
+	}
+	err = validate(string(documentJSON), version)
+	if err == nil || path == "" {
+		return err
+	}
+	message := strings.ReplaceAll(err.Error(), path+".", "")
+	message = strings.ReplaceAll(message, path+": ", "(root): ")
+	return fmt.Errorf("%s", message)
+}
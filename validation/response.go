@@ -0,0 +1,46 @@
+package validation
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// responseSchemas caches the compiled response schemas by their JSON text, since the
+// prompts of a corpus usually share a few schemas.
+var responseSchemas sync.Map
+
+// ValidateResponse checks a model answer against the JSON Schema given for its prompt.
+//
+// Parameters:
+//   - jsonString: The answer to validate.
+//   - schema: The JSON Schema the answer must conform to.
+//
+// Returns:
+//   - An error if the schema is invalid or the answer does not conform to it, or nil if it does.
+func ValidateResponse(jsonString string, schema string) error {
+	compiled, err := compileResponseSchema(schema)
+	if err != nil {
+		return err
+	}
+
+	result, err := compiled.Validate(gojsonschema.NewStringLoader(jsonString))
+	if err != nil {
+		return fmt.Errorf("error during validation: %v", err)
+	}
+	return resultError(result)
+}
+
+// compileResponseSchema compiles a response schema, reusing a cached one when available.
+func compileResponseSchema(schema string) (*gojsonschema.Schema, error) {
+	if cached, ok := responseSchemas.Load(schema); ok {
+		return cached.(*gojsonschema.Schema), nil
+	}
+	compiled, err := gojsonschema.NewSchema(gojsonschema.NewStringLoader(schema))
+	if err != nil {
+		return nil, fmt.Errorf("invalid response schema: %v", err)
+	}
+	responseSchemas.Store(schema, compiled)
+	return compiled, nil
+}
@@ -18,14 +18,9 @@ import (
 // Returns:
 //   - An error if validation fails, or nil if validation succeeds.
 func validateJSON(jsonString, version, schemaType string) error {
-	schemaMap, versionExists := definitions.SchemaStore[version]
-	if !versionExists {
-		return fmt.Errorf("no schemas found for version %s", version)
-	}
-
-	schema, typeExists := schemaMap[schemaType]
-	if !typeExists {
-		return fmt.Errorf("no schema found for type %s in version %s", schemaType, version)
+	schema, err := definitions.LookupSchema(version, schemaType)
+	if err != nil {
+		return err
 	}
 
 	documentLoader := gojsonschema.NewStringLoader(jsonString)
@@ -34,6 +29,11 @@ func validateJSON(jsonString, version, schemaType string) error {
 		return fmt.Errorf("error during validation: %v", err)
 	}
 
+	return resultError(result)
+}
+
+// resultError joins the errors of a validation result, or returns nil if the document is valid.
+func resultError(result *gojsonschema.Result) error {
 	if result.Valid() {
 		return nil
 	}
@@ -55,10 +55,10 @@ func validateJSON(jsonString, version, schemaType string) error {
 // Returns:
 //   - An error if validation fails, or nil if it succeeds.
 func ValidateInput(jsonString string, version string) error {
-	if _, ok := definitions.SchemaStore[version]["input"]; !ok {
+	if _, err := definitions.LookupSchema(version, "input"); err != nil {
 		logger.Info(fmt.Sprintf("Schema version %s not found. Trying fallback version...", version))
 		version = fallbackVersion(version) // Convert "1.0" → "v1"
-		if _, ok := definitions.SchemaStore[version]["input"]; !ok {
+		if _, err := definitions.LookupSchema(version, "input"); err != nil {
 			logger.Info(fmt.Sprintf("Loading schema for version %s\n", version))
 			if err := definitions.LoadSchema(version, "input"); err != nil {
 				logger.Error(err)
@@ -78,10 +78,10 @@ func ValidateInput(jsonString string, version string) error {
 // Returns:
 //   - An error if validation fails, or nil if it succeeds.
 func ValidateOutput(jsonString string, version string) error {
-	if _, ok := definitions.SchemaStore[version]["output"]; !ok {
+	if _, err := definitions.LookupSchema(version, "output"); err != nil {
 		logger.Info(fmt.Sprintf("Schema version %s not found. Trying fallback version...", version))
 		version = fallbackVersion(version) // Convert "1.0" → "v1"
-		if _, ok := definitions.SchemaStore[version]["output"]; !ok {
+		if _, err := definitions.LookupSchema(version, "output"); err != nil {
 			logger.Info(fmt.Sprintf("Loading schema for version %s\n", version))
 			if err := definitions.LoadSchema(version, "output"); err != nil {
 				logger.Error(err)
@@ -101,7 +101,7 @@ func ValidateOutput(jsonString string, version string) error {
 // Returns:
 //   - An error if validation fails, or nil if it succeeds.
 func ValidateCost(jsonString string, version string) error {
-	if _, ok := definitions.SchemaStore[version]["cost"]; !ok {
+	if _, err := definitions.LookupSchema(version, "cost"); err != nil {
 		logger.Info(fmt.Sprintf("Loading schema for version %s\n", version))
 		if err := definitions.LoadSchema(version, "cost"); err != nil {
 			logger.Error(err)
@@ -129,6 +129,267 @@ func TestValidateInput(t *testing.T) {
 			expectsError: true,
 			errorMsg:     "validation errors: (root): models is required; (root): prompts is required; metadata: timestamp is required",
 		},
+		{
+			name: "Valid Input With Fallbacks",
+			jsonInput: `{
+				"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+				"models": [
+					{
+						"provider": "OpenAI",
+						"model": "gpt-4.1-mini",
+						"temperature": 0.7,
+						"fallbacks": [
+							{"provider": "GoogleAI", "model": "gemini-2.5-flash", "temperature": 0.7},
+							{"provider": "SelfHosted", "model": "llama3", "temperature": 0.7, "base_url": "http://localhost:8000/v1"}
+						]
+					}
+				],
+				"prompts": [{"promptContent": "Hello", "sequenceId": "123", "sequenceNumber": 1}]
+			}`,
+			version:      "v2",
+			expectsError: false,
+		},
+		{
+			name: "Invalid Input - Nested Fallbacks",
+			jsonInput: `{
+				"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+				"models": [
+					{
+						"provider": "OpenAI",
+						"model": "gpt-4.1-mini",
+						"temperature": 0.7,
+						"fallbacks": [
+							{"provider": "GoogleAI", "model": "gemini-2.5-flash", "temperature": 0.7, "fallbacks": []}
+						]
+					}
+				],
+				"prompts": [{"promptContent": "Hello", "sequenceId": "123", "sequenceNumber": 1}]
+			}`,
+			version:      "v2",
+			expectsError: true,
+			errorMsg:     "models.0.fallbacks.0",
+		},
+		{
+			name: "Valid Input With Examples",
+			jsonInput: `{
+				"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+				"examples": [{"input": "Abstract: Seagrass in Florida.", "output": {"sites": ["Florida"]}}],
+				"models": [{"provider": "OpenAI", "model": "gpt-4.1-mini", "temperature": 0.7}],
+				"prompts": [{"promptContent": "Summarize", "sequenceId": "123", "sequenceNumber": 1, "responseFormat": "text", "examples": [{"input": "Summarize: Kelp.", "output": "A study of kelp."}]}]
+			}`,
+			version:      "v2",
+			expectsError: false,
+		},
+		{
+			name: "Invalid Input - Example Without Output",
+			jsonInput: `{
+				"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+				"models": [{"provider": "OpenAI", "model": "gpt-4.1-mini", "temperature": 0.7}],
+				"prompts": [{"promptContent": "Hello", "sequenceId": "123", "sequenceNumber": 1, "examples": [{"input": "Hi"}]}]
+			}`,
+			version:      "v2",
+			expectsError: true,
+			errorMsg:     "examples",
+		},
+		{
+			name: "Valid Input With Conditions",
+			jsonInput: `{
+				"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+				"models": [{"provider": "OpenAI", "model": "gpt-4.1-mini", "temperature": 0.7}],
+				"prompts": [
+					{"promptContent": "Screen", "sequenceId": "123", "sequenceNumber": 1},
+					{"promptContent": "Outcomes", "sequenceId": "123", "sequenceNumber": 2, "condition": {"expression": "step1.included == true && step1.score >= 3", "otherwise": "stop"}},
+					{"promptContent": "Design", "sequenceId": "123", "sequenceNumber": 3, "condition": {"path": "step1.design", "equals": "RCT", "otherwise": "step4"}},
+					{"promptContent": "Sites", "sequenceId": "123", "sequenceNumber": 4}
+				]
+			}`,
+			version:      "v2",
+			expectsError: false,
+		},
+		{
+			name: "Invalid Input - Condition With Expression And Path",
+			jsonInput: `{
+				"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+				"models": [{"provider": "OpenAI", "model": "gpt-4.1-mini", "temperature": 0.7}],
+				"prompts": [{"promptContent": "Hello", "sequenceId": "123", "sequenceNumber": 2, "condition": {"expression": "step1.included", "path": "step1.included", "equals": true}}]
+			}`,
+			version:      "v2",
+			expectsError: true,
+			errorMsg:     "condition",
+		},
+		{
+			name: "Invalid Input - Condition Otherwise Unknown",
+			jsonInput: `{
+				"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+				"models": [{"provider": "OpenAI", "model": "gpt-4.1-mini", "temperature": 0.7}],
+				"prompts": [{"promptContent": "Hello", "sequenceId": "123", "sequenceNumber": 2, "condition": {"expression": "step1.included", "otherwise": "exit"}}]
+			}`,
+			version:      "v2",
+			expectsError: true,
+			errorMsg:     "otherwise",
+		},
+		{
+			name: "Valid Input With Stateless Model And References",
+			jsonInput: `{
+				"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+				"models": [{"provider": "OpenAI", "model": "gpt-4.1-mini", "temperature": 0.7, "stateless": true}],
+				"prompts": [
+					{"promptContent": "List the outcomes", "sequenceId": "123", "sequenceNumber": 1},
+					{"promptContent": "Effect sizes of {{step1.outcomes}}", "sequenceId": "123", "sequenceNumber": 2}
+				]
+			}`,
+			version:      "v2",
+			expectsError: false,
+		},
+		{
+			name: "Invalid Input - Stateless Not A Boolean",
+			jsonInput: `{
+				"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+				"models": [{"provider": "OpenAI", "model": "gpt-4.1-mini", "temperature": 0.7, "stateless": "yes"}],
+				"prompts": [{"promptContent": "Hello", "sequenceId": "123", "sequenceNumber": 1}]
+			}`,
+			version:      "v2",
+			expectsError: true,
+			errorMsg:     "models.0.stateless",
+		},
+		{
+			name: "Valid Input With System Prompts",
+			jsonInput: `{
+				"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+				"systemPrompt": "You are a careful research assistant.",
+				"models": [{"provider": "OpenAI", "model": "gpt-4.1-mini", "temperature": 0.7, "system_prompt": "Answer in English."}],
+				"prompts": [{"promptContent": "Hello", "sequenceId": "123", "sequenceNumber": 1, "systemPrompt": "Answer in French."}]
+			}`,
+			version:      "v2",
+			expectsError: false,
+		},
+		{
+			name: "Invalid Input - System Prompt Not A String",
+			jsonInput: `{
+				"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+				"systemPrompt": ["You are a careful research assistant."],
+				"models": [{"provider": "OpenAI", "model": "gpt-4.1-mini", "temperature": 0.7}],
+				"prompts": [{"promptContent": "Hello", "sequenceId": "123", "sequenceNumber": 1}]
+			}`,
+			version:      "v2",
+			expectsError: true,
+			errorMsg:     "systemPrompt",
+		},
+		{
+			name: "Valid Input With Generation Parameters",
+			jsonInput: `{
+				"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+				"models": [{
+					"provider": "SelfHosted", "model": "llama3", "temperature": 0.2, "base_url": "http://localhost:8000/v1",
+					"max_output_tokens": 2048, "top_p": 0.9, "top_k": 40, "seed": 7, "stop": ["END"],
+					"presence_penalty": 0.5, "frequency_penalty": -0.5, "extra_params": {"min_p": 0.05}
+				}],
+				"prompts": [{"promptContent": "Hello", "sequenceId": "123", "sequenceNumber": 1}]
+			}`,
+			version:      "v2",
+			expectsError: false,
+		},
+		{
+			name: "Valid Input With Ollama Settings",
+			jsonInput: `{
+				"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+				"models": [{
+					"provider": "Ollama", "model": "llama3.1", "temperature": 0.2,
+					"keep_alive": "10m", "num_ctx": 8192, "options": {"mirostat": 2}
+				}],
+				"prompts": [{"promptContent": "Hello", "sequenceId": "123", "sequenceNumber": 1}]
+			}`,
+			version:      "v2",
+			expectsError: false,
+		},
+		{
+			name: "Invalid Input - Ollama Context Size Zero",
+			jsonInput: `{
+				"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+				"models": [{"provider": "Ollama", "model": "llama3.1", "temperature": 0.2, "num_ctx": 0}],
+				"prompts": [{"promptContent": "Hello", "sequenceId": "123", "sequenceNumber": 1}]
+			}`,
+			version:      "v2",
+			expectsError: true,
+			errorMsg:     "models.0.num_ctx",
+		},
+		{
+			name: "Invalid Input - Top P Out Of Range",
+			jsonInput: `{
+				"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+				"models": [{"provider": "OpenAI", "model": "gpt-4.1-mini", "temperature": 0.7, "top_p": 1.5}],
+				"prompts": [{"promptContent": "Hello", "sequenceId": "123", "sequenceNumber": 1}]
+			}`,
+			version:      "v2",
+			expectsError: true,
+			errorMsg:     "models.0.top_p",
+		},
+		{
+			name: "Valid Input With Response Formats",
+			jsonInput: `{
+				"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+				"models": [{"provider": "OpenAI", "model": "gpt-4.1-mini", "temperature": 0.7}],
+				"prompts": [
+					{"promptContent": "Summarize the abstract.", "sequenceId": "123", "sequenceNumber": 1, "responseFormat": "text"},
+					{"promptContent": "List the authors.", "sequenceId": "123", "sequenceNumber": 2, "responseFormat": "json"},
+					{"promptContent": "Give the year.", "sequenceId": "123", "sequenceNumber": 3, "responseFormat": "json_schema", "responseSchema": {"type": "object"}}
+				]
+			}`,
+			version:      "v2",
+			expectsError: false,
+		},
+		{
+			name: "Invalid Input - JSON Schema Format Without Schema",
+			jsonInput: `{
+				"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+				"models": [{"provider": "OpenAI", "model": "gpt-4.1-mini", "temperature": 0.7}],
+				"prompts": [{"promptContent": "Hello", "sequenceId": "123", "sequenceNumber": 1, "responseFormat": "json_schema"}]
+			}`,
+			version:      "v2",
+			expectsError: true,
+			errorMsg:     "prompts.0: responseSchema is required",
+		},
+		{
+			name: "Invalid Input - Text Format With Schema",
+			jsonInput: `{
+				"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+				"models": [{"provider": "OpenAI", "model": "gpt-4.1-mini", "temperature": 0.7}],
+				"prompts": [{"promptContent": "Hello", "sequenceId": "123", "sequenceNumber": 1, "responseFormat": "text", "responseSchema": {"type": "object"}}]
+			}`,
+			version:      "v2",
+			expectsError: true,
+			errorMsg:     "prompts.0",
+		},
+		{
+			name: "Valid Input With Templates",
+			jsonInput: `{
+				"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+				"models": [{"provider": "OpenAI", "model": "gpt-4.1-mini", "temperature": 0.7}],
+				"prompts": [],
+				"templates": {
+					"prompts": [{"promptContent": "Summarize: {{abstract}}", "sequenceNumber": 1, "responseFormat": "text"}],
+					"records": [{"id": "w1", "abstract": "Corals"}],
+					"source": {"path": "papers.csv"}
+				}
+			}`,
+			version:      "v2",
+			expectsError: false,
+		},
+		{
+			name: "Invalid Input - Template With Sequence ID",
+			jsonInput: `{
+				"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"},
+				"models": [{"provider": "OpenAI", "model": "gpt-4.1-mini", "temperature": 0.7}],
+				"prompts": [],
+				"templates": {
+					"prompts": [{"promptContent": "Summarize: {{abstract}}", "sequenceId": "1", "sequenceNumber": 1}],
+					"records": [{"abstract": "Corals"}]
+				}
+			}`,
+			version:      "v2",
+			expectsError: true,
+			errorMsg:     "templates.prompts.0",
+		},
 		{
 			name: "Invalid Version - Schema Not Found",
 			jsonInput: `{
@@ -186,3 +447,101 @@ func TestValidateOutput(t *testing.T) {
 		t.Errorf("Expected no error, but got: %v", err)
 	}
 }
+
+func TestValidateLines(t *testing.T) {
+	tests := []struct {
+		name         string
+		validate     func(string, string) error
+		jsonLine     string
+		expectsError bool
+		errorMsg     string
+	}{
+		{
+			name:     "Valid Input Header",
+			validate: ValidateInputHeader,
+			jsonLine: `{"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"}, "models": [{"provider": "OpenAI", "model": "gpt-4o", "temperature": 0.7}]}`,
+		},
+		{
+			name:         "Input Header Missing Models",
+			validate:     ValidateInputHeader,
+			jsonLine:     `{"metadata": {"schemaVersion": "v2", "timestamp": "2025-02-10T12:00:00Z"}}`,
+			expectsError: true,
+			errorMsg:     "validation errors: (root): models is required",
+		},
+		{
+			name:     "Valid Prompt Line",
+			validate: ValidateInputLine,
+			jsonLine: `{"promptContent": "Hello", "sequenceId": "123", "sequenceNumber": 1}`,
+		},
+		{
+			name:         "Prompt Line With Invalid Sequence Number",
+			validate:     ValidateInputLine,
+			jsonLine:     `{"promptContent": "Hello", "sequenceId": "123", "sequenceNumber": 0}`,
+			expectsError: true,
+			errorMsg:     "validation errors: sequenceNumber: Must be greater than or equal to 1",
+		},
+		{
+			name:         "Prompt Line Not An Object",
+			validate:     ValidateInputLine,
+			jsonLine:     `["Hello"]`,
+			expectsError: true,
+			errorMsg:     "line is not a JSON object",
+		},
+		{
+			name:     "Valid Response Line",
+			validate: ValidateOutputLine,
+			jsonLine: `{"provider": "OpenAI", "model": "gpt-4o", "sequenceId": "123", "sequenceNumber": 1, "modelResponses": ["{}"]}`,
+		},
+		{
+			name:         "Response Line With Unknown Field",
+			validate:     ValidateOutputLine,
+			jsonLine:     `{"provider": "OpenAI", "model": "gpt-4o", "sequenceId": "123", "modelResponses": [], "extra": true}`,
+			expectsError: true,
+			errorMsg:     "validation errors: (root): Additional property extra is not allowed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.validate(tt.jsonLine, "v2")
+			if tt.expectsError {
+				if err == nil {
+					t.Errorf("Expected error but got nil")
+				} else if !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Errorf("Expected error containing '%s', got '%s'", tt.errorMsg, err.Error())
+				}
+			} else if err != nil {
+				t.Errorf("Expected no error but got: %s", err)
+			}
+		})
+	}
+}
+
+func TestValidateResponse(t *testing.T) {
+	schema := `{"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}`
+	tests := []struct {
+		name     string
+		response string
+		schema   string
+		errorMsg string
+	}{
+		{name: "Conforming Answer", response: `{"city": "Paris"}`, schema: schema},
+		{name: "Missing Property", response: `{"town": "Paris"}`, schema: schema, errorMsg: "validation errors: (root): city is required"},
+		{name: "Wrong Type", response: `{"city": 75}`, schema: schema, errorMsg: "validation errors: city: Invalid type. Expected: string, given: integer"},
+		{name: "Not JSON", response: `Paris`, schema: schema, errorMsg: "error during validation"},
+		{name: "Invalid Schema", response: `{"city": "Paris"}`, schema: `{"type": 5}`, errorMsg: "invalid response schema"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateResponse(tt.response, tt.schema)
+			if tt.errorMsg == "" {
+				if err != nil {
+					t.Errorf("Expected no error but got: %s", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.errorMsg) {
+				t.Errorf("Expected error containing '%s', got '%v'", tt.errorMsg, err)
+			}
+		})
+	}
+}